@@ -62,9 +62,13 @@ func (b linearBackoff) waitDuration(attempt int) time.Duration {
 }
 
 // jitter adjusts the baseDelay +/- 33%
+// A baseDelay under 3ns has no room to jitter, so it's returned unchanged
 func jitter(baseDelay time.Duration) time.Duration {
 	delayNs := baseDelay.Nanoseconds()
 	maxJitter := delayNs / 3
+	if maxJitter <= 0 {
+		return baseDelay
+	}
 
 	delayNs += rand.Int63n(2*maxJitter) - maxJitter
 
@@ -0,0 +1,23 @@
+// Package brotli registers a brotli Decompressor with fetcher
+// Import it for its side effects to allow WithDecompression to handle Content-Encoding: br
+//
+//	import _ "github.com/nozzle/fetcher/brotli"
+package brotli
+
+import (
+	"io"
+
+	"github.com/andybalholm/brotli"
+	"github.com/nozzle/fetcher"
+)
+
+// Encoding is the Content-Encoding value for brotli compressed bodies
+const Encoding = "br"
+
+func init() {
+	fetcher.RegisterDecompressor(Encoding, decompress)
+}
+
+func decompress(r io.Reader) (io.Reader, error) {
+	return brotli.NewReader(r), nil
+}
@@ -0,0 +1,47 @@
+package brotli_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/nozzle/fetcher"
+	fetcherbrotli "github.com/nozzle/fetcher/brotli"
+)
+
+func TestBrotliDecompression(t *testing.T) {
+	c := context.Background()
+	want := `{"URL":"https://nozzle.io/","Count":30}`
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(fetcher.AcceptEncodingHeader) != fetcherbrotli.Encoding {
+			t.Errorf("Accept-Encoding = %s, want %s", r.Header.Get(fetcher.AcceptEncodingHeader), fetcherbrotli.Encoding)
+		}
+		w.Header().Set(fetcher.ContentEncodingHeader, fetcherbrotli.Encoding)
+		bw := brotli.NewWriter(w)
+		bw.Write([]byte(want))
+		bw.Close()
+	}))
+	defer ts.Close()
+
+	cl, err := fetcher.NewClient(c)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	resp, err := cl.Get(c, ts.URL, fetcher.WithDecompression(fetcherbrotli.Encoding))
+	if err != nil {
+		t.Fatalf("cl.Get failed: %v", err)
+	}
+	defer resp.Close()
+
+	got, err := resp.Bytes()
+	if err != nil {
+		t.Fatalf("resp.Bytes failed: %v", err)
+	}
+	if string(got) != want {
+		t.Errorf("got = %s, want %s", got, want)
+	}
+}
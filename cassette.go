@@ -0,0 +1,218 @@
+package fetcher
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Cassette holds the interactions recorded by WithCassette, stored as JSON, e.g.
+//
+//	{
+//	  "interactions": [
+//	    {
+//	      "request": {"method": "POST", "url": "https://api.example.com/items", "headers": {"Content-Type": ["application/json"]}, "body": "{\"name\":\"a\"}"},
+//	      "response": {"statusCode": 201, "status": "201 Created", "headers": {"Content-Type": ["application/json"]}, "body": "{\"id\":1}"}
+//	    }
+//	  ]
+//	}
+type Cassette struct {
+	Interactions []CassetteInteraction `json:"interactions"`
+}
+
+// CassetteInteraction is a single recorded request and its response
+type CassetteInteraction struct {
+	Request  CassetteRequest  `json:"request"`
+	Response CassetteResponse `json:"response"`
+}
+
+// CassetteRequest is a recorded request
+// Authorization, Cookie, Set-Cookie and Proxy-Authorization header values are redacted, so they aren't saved to the file
+type CassetteRequest struct {
+	Method  string      `json:"method"`
+	URL     string      `json:"url"`
+	Headers http.Header `json:"headers,omitempty"`
+	Body    string      `json:"body,omitempty"`
+}
+
+// CassetteResponse is a recorded response
+type CassetteResponse struct {
+	StatusCode int         `json:"statusCode"`
+	Status     string      `json:"status,omitempty"`
+	Headers    http.Header `json:"headers,omitempty"`
+	Body       string      `json:"body,omitempty"`
+}
+
+// CassetteMatcher reports whether the recorded request matches the request r being replayed, with its body
+type CassetteMatcher func(r *http.Request, body []byte, recorded CassetteRequest) bool
+
+// DefaultCassetteMatcher matches requests on their method, url and body
+func DefaultCassetteMatcher(r *http.Request, body []byte, recorded CassetteRequest) bool {
+	return r.Method == recorded.Method && r.URL.String() == recorded.URL && string(body) == recorded.Body
+}
+
+// WithCassette is a ClientOption that records every request and response to the JSON Cassette file at path,
+// or replays them from the file if it already exists, so tests can run against a real API once and offline afterwards
+// Requests are matched with DefaultCassetteMatcher unless WithCassetteMatcher is given,
+// and a request that matches no recorded interaction fails when replaying
+// Delete the file to record it again
+func WithCassette(path string) ClientOption {
+	return func(c context.Context, cl *Client) error {
+		cst := &cassetteTransport{path: path}
+		b, err := ioutil.ReadFile(path)
+		switch {
+		case os.IsNotExist(err):
+		case err != nil:
+			return err
+		default:
+			if err = json.Unmarshal(b, &cst.cassette); err != nil {
+				return fmt.Errorf("invalid cassette %s: %w", path, err)
+			}
+			cst.replay = true
+			cst.replayed = make([]bool, len(cst.cassette.Interactions))
+		}
+		cl.cassette = cst
+		return nil
+	}
+}
+
+// WithCassetteMatcher is a ClientOption that sets how WithCassette matches requests to recorded interactions
+func WithCassetteMatcher(matcher CassetteMatcher) ClientOption {
+	return func(c context.Context, cl *Client) error {
+		cl.cassetteMatcher = matcher
+		return nil
+	}
+}
+
+// cassetteTransport is an http.RoundTripper that records the interactions of base, or replays them
+type cassetteTransport struct {
+	path    string
+	base    http.RoundTripper
+	matcher CassetteMatcher
+	replay  bool
+
+	mu       sync.Mutex
+	cassette Cassette
+
+	// replayed interactions, which are only replayed again once every match has been used
+	replayed []bool
+}
+
+// RoundTrip implements http.RoundTripper
+func (cst *cassetteTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	var body []byte
+	if r.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		// a RoundTripper mustn't modify the request, so send a copy with the buffered body
+		r = r.Clone(r.Context())
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	if cst.replay {
+		return cst.replayInteraction(r, body)
+	}
+	return cst.recordInteraction(r, body)
+}
+
+// replayInteraction returns the response of the first matching interaction that hasn't been replayed yet,
+// falling back to the first matching interaction
+func (cst *cassetteTransport) replayInteraction(r *http.Request, body []byte) (*http.Response, error) {
+	cst.mu.Lock()
+	defer cst.mu.Unlock()
+
+	match := -1
+	for i, interaction := range cst.cassette.Interactions {
+		if !cst.matcher(r, body, interaction.Request) {
+			continue
+		}
+		if !cst.replayed[i] {
+			match = i
+			break
+		}
+		if match == -1 {
+			match = i
+		}
+	}
+	if match == -1 {
+		return nil, fmt.Errorf("no interaction in cassette %s matches %s %s", cst.path, r.Method, r.URL)
+	}
+	cst.replayed[match] = true
+
+	recorded := cst.cassette.Interactions[match].Response
+	return &http.Response{
+		Status:        recorded.Status,
+		StatusCode:    recorded.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        recorded.Headers.Clone(),
+		Body:          ioutil.NopCloser(strings.NewReader(recorded.Body)),
+		ContentLength: int64(len(recorded.Body)),
+		Request:       r,
+	}, nil
+}
+
+// recordInteraction sends the request with base and saves the interaction to the cassette file
+func (cst *cassetteTransport) recordInteraction(r *http.Request, body []byte) (*http.Response, error) {
+	resp, err := cst.base.RoundTrip(r)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+
+	cst.mu.Lock()
+	defer cst.mu.Unlock()
+	cst.cassette.Interactions = append(cst.cassette.Interactions, CassetteInteraction{
+		Request: CassetteRequest{
+			Method:  r.Method,
+			URL:     r.URL.String(),
+			Headers: redactCassetteHeader(r.Header),
+			Body:    string(body),
+		},
+		Response: CassetteResponse{
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			Headers:    redactCassetteHeader(resp.Header),
+			Body:       string(respBody),
+		},
+	})
+
+	b, err := json.MarshalIndent(cst.cassette, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err = ioutil.WriteFile(cst.path, b, 0644); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// redactCassetteHeader returns a copy of h with the defaultRedactedHeaders redacted
+func redactCassetteHeader(h http.Header) http.Header {
+	if len(h) == 0 {
+		return nil
+	}
+	redactedHeader := h.Clone()
+	for _, key := range defaultRedactedHeaders {
+		if _, ok := redactedHeader[http.CanonicalHeaderKey(key)]; ok {
+			redactedHeader[http.CanonicalHeaderKey(key)] = []string{redacted}
+		}
+	}
+	return redactedHeader
+}
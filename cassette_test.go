@@ -0,0 +1,101 @@
+package fetcher
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func TestCassette(t *testing.T) {
+	c := context.Background()
+	dir, err := ioutil.TempDir("", "fetcher")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cassette.json")
+
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set(ContentTypeHeader, ContentTypeJSON)
+		w.WriteHeader(http.StatusCreated)
+		w.Write(append([]byte("echo:"), body...))
+	}))
+	url := ts.URL + "/items"
+
+	// the first client records against the server
+	cl, err := NewClient(c, WithCassette(path))
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	for _, payload := range []string{"a", "b"} {
+		resp, err := cl.Post(c, url, WithBody(payload, ContentTypeJSON), WithBasicAuth("user", "secret"))
+		if err != nil {
+			t.Fatalf("recording cl.Post failed: %v", err)
+		}
+		if got := string(resp.MustBytes()); got != "echo:"+payload {
+			t.Errorf("recorded body = %s, want echo:%s", got, payload)
+		}
+	}
+	ts.Close()
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading cassette failed: %v", err)
+	}
+	if bytes.Contains(b, []byte("dXNlcjpzZWNyZXQ=")) {
+		t.Errorf("cassette = %s, want the Authorization header redacted", b)
+	}
+
+	// the second client replays without the server, matching on the body
+	cl, err = NewClient(c, WithCassette(path))
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	for _, payload := range []string{"b", "a"} {
+		resp, err := cl.Post(c, url, WithBody(payload, ContentTypeJSON))
+		if err != nil {
+			t.Fatalf("replaying cl.Post failed: %v", err)
+		}
+		if resp.StatusCode() != http.StatusCreated {
+			t.Errorf("replayed StatusCode = %d, want %d", resp.StatusCode(), http.StatusCreated)
+		}
+		if resp.ContentType() != ContentTypeJSON {
+			t.Errorf("replayed ContentType = %s, want %s", resp.ContentType(), ContentTypeJSON)
+		}
+		if got := string(resp.MustBytes()); got != "echo:"+payload {
+			t.Errorf("replayed body = %s, want echo:%s", got, payload)
+		}
+	}
+	if _, err = cl.Post(c, url, WithBody("c", ContentTypeJSON)); err == nil {
+		t.Error("replaying an unrecorded request: err = nil, want no matching interaction")
+	}
+	if hits != 2 {
+		t.Errorf("server hits = %d, want 2", hits)
+	}
+
+	// a custom matcher ignoring the body replays the first unused interaction
+	cl, err = NewClient(c, WithCassette(path), WithCassetteMatcher(func(r *http.Request, body []byte, recorded CassetteRequest) bool {
+		return r.Method == recorded.Method && r.URL.String() == recorded.URL
+	}))
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	for _, want := range []string{"echo:a", "echo:b"} {
+		resp, err := cl.Post(c, url, WithBody("c", ContentTypeJSON))
+		if err != nil {
+			t.Fatalf("replaying cl.Post failed: %v", err)
+		}
+		if got := string(resp.MustBytes()); got != want {
+			t.Errorf("replayed body = %s, want %s", got, want)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package fetcher
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"hash"
+	"sort"
+	"strings"
+)
+
+const (
+	// ContentMD5Header = "Content-MD5"
+	ContentMD5Header = "Content-MD5"
+
+	// DigestHeader = "Digest"
+	DigestHeader = "Digest"
+)
+
+// digestAlgorithms are the RFC 3230 Digest algorithms verified by WithVerifyChecksum
+var digestAlgorithms = map[string]func() hash.Hash{
+	"md5":     md5.New,
+	"sha":     sha1.New,
+	"sha-256": sha256.New,
+	"sha-512": sha512.New,
+}
+
+// checksum is a header set to the base64 encoded hash of the payload
+type checksum struct {
+	header string
+	hash   hash.Hash
+}
+
+// WithContentMD5 sets the Content-MD5 header to the base64 encoded MD5 of the payload
+// The payload is buffered to compute it, see WithContentChecksum
+func WithContentMD5() RequestOption {
+	return func(c context.Context, req *Request) error {
+		// a hash per Request, so the option can be reused by concurrent requests
+		return WithContentChecksum(ContentMD5Header, md5.New())(c, req)
+	}
+}
+
+// WithContentChecksum sets the header to the base64 encoded hash of the payload (e.g. sha256.New())
+// The checksum is computed once all RequestOptions have run, over the payload as sent (after any compression),
+// so payloads that aren't already buffered are read into memory
+// NOTE: h is reset and written to while the Request is created, so it must not be shared between concurrent requests
+func WithContentChecksum(header string, h hash.Hash) RequestOption {
+	return func(c context.Context, req *Request) error {
+		req.checksums = append(req.checksums, checksum{header: header, hash: h})
+		return nil
+	}
+}
+
+// setChecksumHeaders adds a header for each checksum computed over the payload
+func (req *Request) setChecksumHeaders() error {
+	if len(req.checksums) == 0 || req.payload == nil {
+		return nil
+	}
+
+	payload, err := req.payloadBytes()
+	if err != nil {
+		return err
+	}
+
+	for _, cs := range req.checksums {
+		cs.hash.Reset()
+		cs.hash.Write(payload)
+		req.headers = append(req.headers, newHeader(cs.header, base64.StdEncoding.EncodeToString(cs.hash.Sum(nil))))
+	}
+	return nil
+}
+
+// WithVerifyChecksum verifies the body against the Content-MD5 header and the md5, sha, sha-256 and sha-512
+// values of an RFC 3230 Digest header (e.g. "Digest: sha-256=X48E9q..."), returning a *ChecksumError on a mismatch
+// ErrNoChecksum is returned if the response has none of them, and unsupported Digest algorithms are ignored
+// The body is buffered to verify it, so it remains available from Bytes afterwards
+// NOTE: the checksum is computed over the body as read, after any WithDecompression
+func WithVerifyChecksum() DecodeOption {
+	return func(c context.Context, resp *Response) error {
+		expected := map[string]string{}
+		for _, digest := range strings.Split(resp.response.Header.Get(DigestHeader), ",") {
+			i := strings.Index(digest, "=")
+			if i == -1 {
+				continue
+			}
+			algorithm := strings.ToLower(strings.TrimSpace(digest[:i]))
+			if _, ok := digestAlgorithms[algorithm]; ok {
+				expected[algorithm] = strings.TrimSpace(digest[i+1:])
+			}
+		}
+		// Content-MD5 takes precedence over an md5 Digest value
+		if contentMD5 := resp.response.Header.Get(ContentMD5Header); contentMD5 != "" {
+			expected["md5"] = strings.TrimSpace(contentMD5)
+		}
+		if len(expected) == 0 {
+			return ErrNoChecksum
+		}
+
+		body, err := resp.Bytes()
+		if err != nil {
+			return err
+		}
+
+		// check the algorithms in a stable order, so the same mismatch is always reported
+		algorithms := make([]string, 0, len(expected))
+		for algorithm := range expected {
+			algorithms = append(algorithms, algorithm)
+		}
+		sort.Strings(algorithms)
+
+		for _, algorithm := range algorithms {
+			want := expected[algorithm]
+			h := digestAlgorithms[algorithm]()
+			h.Write(body)
+			if got := base64.StdEncoding.EncodeToString(h.Sum(nil)); got != want {
+				return &ChecksumError{Algorithm: algorithm, Expected: want, Actual: got}
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,153 @@
+package fetcher
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestContentChecksum(t *testing.T) {
+	const payload = `{"url":"https://example.com","count":1}`
+	md5Sum := md5.Sum([]byte(payload))
+	sha256Sum := sha256.Sum256([]byte(payload))
+	wantMD5 := base64.StdEncoding.EncodeToString(md5Sum[:])
+	wantSHA256 := base64.StdEncoding.EncodeToString(sha256Sum[:])
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("reading body failed: %v", err)
+			return
+		}
+		if string(body) != payload {
+			t.Errorf("body = %s, want %s", body, payload)
+		}
+		if got := r.Header.Get(ContentMD5Header); got != wantMD5 {
+			t.Errorf("%s = %s, want %s", ContentMD5Header, got, wantMD5)
+		}
+		if got := r.Header.Get("X-Checksum-Sha256"); got != wantSHA256 {
+			t.Errorf("X-Checksum-Sha256 = %s, want %s", got, wantSHA256)
+		}
+	}))
+	defer ts.Close()
+
+	c := context.Background()
+	cl, err := NewClient(c)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	// an io.Reader payload is buffered, so it can be both hashed and sent
+	resp, err := cl.Put(c, ts.URL,
+		WithBody(strings.NewReader(payload), ContentTypeJSON),
+		WithContentMD5(),
+		WithContentChecksum("X-Checksum-Sha256", sha256.New()),
+	)
+	if err != nil {
+		t.Fatalf("cl.Put failed: %v", err)
+	}
+	resp.Close()
+}
+
+func TestContentMD5Concurrent(t *testing.T) {
+	c := context.Background()
+	cl, err := NewClient(c)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	// the same option value is shared by every request, e.g. as with WithRequestOptions
+	contentMD5 := WithContentMD5()
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(payload []byte) {
+			defer wg.Done()
+			req, err := cl.NewRequest(c, http.MethodPut, "http://example.com", WithBytesPayload(payload), contentMD5)
+			if err != nil {
+				t.Errorf("NewRequest failed: %v", err)
+				return
+			}
+			sum := md5.Sum(payload)
+			if got, want := req.Header().Get(ContentMD5Header), base64.StdEncoding.EncodeToString(sum[:]); got != want {
+				t.Errorf("%s of %s = %s, want %s", ContentMD5Header, payload, got, want)
+			}
+		}([]byte("payload " + strconv.Itoa(i)))
+	}
+	wg.Wait()
+}
+
+func TestVerifyChecksum(t *testing.T) {
+	body := []byte(`{"url":"https://example.com","count":1}`)
+	md5Sum := md5.Sum(body)
+	sha256Sum := sha256.Sum256(body)
+	validMD5 := base64.StdEncoding.EncodeToString(md5Sum[:])
+	validSHA256 := base64.StdEncoding.EncodeToString(sha256Sum[:])
+	invalid := base64.StdEncoding.EncodeToString([]byte("not the checksum"))
+
+	tests := []struct {
+		name         string
+		headers      map[string]string
+		wantErr      error
+		wantMismatch string
+	}{
+		{"content-md5", map[string]string{ContentMD5Header: validMD5}, nil, ""},
+		{"digest sha-256", map[string]string{DigestHeader: "SHA-256=" + validSHA256}, nil, ""},
+		{"digest multiple", map[string]string{DigestHeader: "unixsum=30637, md5=" + validMD5 + ", sha-256=" + validSHA256}, nil, ""},
+		{"content-md5 mismatch", map[string]string{ContentMD5Header: invalid}, nil, "md5"},
+		{"digest mismatch", map[string]string{ContentMD5Header: validMD5, DigestHeader: "sha-256=" + invalid}, nil, "sha-256"},
+		{"missing", nil, ErrNoChecksum, ""},
+		{"unsupported only", map[string]string{DigestHeader: "unixsum=30637"}, ErrNoChecksum, ""},
+	}
+
+	c := context.Background()
+	cl, err := NewClient(c)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := testServerHelper(t, &serverData{headers: tt.headers, body: body, statusCode: http.StatusOK})
+			defer ts.Close()
+
+			resp, err := cl.Get(c, ts.URL)
+			if err != nil {
+				t.Fatalf("cl.Get failed: %v", err)
+			}
+			var obj testObject
+			err = resp.Decode(c, &obj, WithVerifyChecksum(), WithJSONBody())
+
+			var checksumErr *ChecksumError
+			switch {
+			case tt.wantMismatch != "":
+				if !errors.As(err, &checksumErr) {
+					t.Fatalf("Decode err = %v, want *ChecksumError", err)
+				}
+				if checksumErr.Algorithm != tt.wantMismatch {
+					t.Errorf("ChecksumError.Algorithm = %s, want %s", checksumErr.Algorithm, tt.wantMismatch)
+				}
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("Decode err = %v, want %v", err, tt.wantErr)
+				}
+			default:
+				if err != nil {
+					t.Fatalf("Decode failed: %v", err)
+				}
+				if obj.Count != 1 {
+					t.Errorf("decoded Count = %d, want 1", obj.Count)
+				}
+			}
+		})
+	}
+}
@@ -3,11 +3,17 @@ package fetcher
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"net/http/httptrace"
+	"net/url"
 	"strings"
+	"sync"
 	"time"
 
 	"go.opencensus.io/plugin/ochttp"
@@ -19,16 +25,73 @@ var _ Fetcher = (*Client)(nil)
 type Client struct {
 	client *http.Client
 
+	// the transport under the tracing and cassette transports, kept to close its idle connections
+	transport *http.Transport
+
+	// named transports requests can select with WithTransportName, and the http.Clients built on them
+	transports   map[string]http.RoundTripper
+	namedClients map[string]*http.Client
+
 	// parentRequestOptions will be added to every NewRequest created with this Client
 	parentRequestOptions []RequestOption
 
+	// Accept header for requests that don't set one
+	defaultAccept string
+
+	// run on every response before the request afterDoFuncs
+	afterDoFuncs []func(req *Request, resp *Response) error
+
 	keepAlive           time.Duration
 	handshakeTimeout    time.Duration
 	maxIdleConnsPerHost int
+	maxRedirects        int
+
+	// re-add the Authorization header on redirects to the same host
+	preserveAuthOnRedirect bool
+
+	// host (or host:port) -> ip, set through WithResolveHost
+	resolveHosts map[string]string
+
+	// deadline for each read from a connection, set through WithReadTimeout
+	readTimeout time.Duration
+
+	// source address of outgoing connections, set through WithLocalAddr
+	localAddr net.Addr
+
+	// certificates presented to servers requesting client auth, set through WithTLSClientCert
+	tlsClientCerts []tls.Certificate
+
+	// ALPN protocols offered in the TLS handshake, set through WithALPN
+	alpnProtocols []string
+
+	// stop the transport requesting gzip and transparently decompressing it
+	disableCompression bool
+
+	// limit on the size of the response headers, set through WithMaxResponseHeaderBytes
+	maxResponseHeaderBytes int64
+
+	// limit on the size of every response body, set through WithClientMaxResponseBytes
+	maxResponseBytes int64
+
+	// largest unread body Response.Close reads to keep the connection, set through WithDrainOnClose
+	drainOnClose int64
+
+	// records or replays the interactions of the transport, set through WithCassette
+	cassette        *cassetteTransport
+	cassetteMatcher CassetteMatcher
+
+	// identical requests within this window are merged into one, set through WithCoalesce
+	coalesceWindow time.Duration
 
 	// Rate Limiting
 	rateLimit rateLimit
 
+	// per host rate limits, used instead of rateLimit for their hosts
+	hostRateLimits *hostRateLimits
+
+	// semaphore capping the requests in flight, set through WithMaxConcurrentRequests
+	requestSem chan struct{}
+
 	errorLogFunc LogFunc
 	debugLogFunc LogFunc
 }
@@ -38,6 +101,7 @@ func NewClient(c context.Context, opts ...ClientOption) (*Client, error) {
 	cl := &Client{
 		keepAlive:        60 * time.Second,
 		handshakeTimeout: 10 * time.Second,
+		drainOnClose:     defaultDrainOnClose,
 	}
 
 	var err error
@@ -55,10 +119,34 @@ func NewClient(c context.Context, opts ...ClientOption) (*Client, error) {
 }
 
 // Do uses the client receiver to execute the provided request
+// Errors sending the request are returned as a *RequestError
 func (cl *Client) Do(c context.Context, req *Request) (*Response, error) {
+	// the pooled payload buffer is released once every attempt, including fallbacks, is done
+	defer req.releasePayloadBuffer()
+
 	// if the context has been canceled or the deadline exceeded, don't start the request
 	if c.Err() != nil {
-		return nil, c.Err()
+		req.abandonPayload()
+		return nil, req.newRequestError(c.Err())
+	}
+
+	// resolve the transport before anything else, so a typo fails fast
+	if req.transportName != "" {
+		if _, ok := cl.namedClients[req.transportName]; !ok {
+			req.abandonPayload()
+			return nil, req.newRequestError(fmt.Errorf("no transport registered named %q", req.transportName))
+		}
+	}
+
+	// wait for a slot if the client is at its concurrent requests limit
+	if cl.requestSem != nil {
+		select {
+		case cl.requestSem <- struct{}{}:
+			defer func() { <-cl.requestSem }()
+		case <-c.Done():
+			req.abandonPayload()
+			return nil, req.newRequestError(c.Err())
+		}
 	}
 
 	// if per request loggers haven't been set, inherit from the client
@@ -87,16 +175,63 @@ func (cl *Client) Do(c context.Context, req *Request) (*Response, error) {
 
 	req.client = cl
 
+	req.logPayload()
+
+	// bodyCancelFunc allows Decode to abort an in-progress body read, and is released by resp.Close
+	c, bodyCancelFunc := context.WithCancel(c)
+
+	// fallbacks resend the payload, so make sure it can be replayed
+	if len(req.fallbackURLs) > 0 {
+		if _, err := req.payloadBytes(); err != nil {
+			bodyCancelFunc()
+			return nil, err
+		}
+	}
+
+	start := time.Now()
 	httpResp, err := doWithRetries(c, req)
+
+	// fail over to the fallback URLs in order while the request keeps failing
+	for _, fallbackURL := range req.fallbackURLs {
+		if (err == nil && httpResp.StatusCode < 500) || c.Err() != nil {
+			break
+		}
+		if httpResp != nil {
+			httpResp.Body.Close()
+		}
+		req.errorf("request failed after %d attempts, failing over to %s | req: %s", req.maxAttempts, fallbackURL, req.String())
+		if err = req.useFallbackURL(fallbackURL); err != nil {
+			break
+		}
+		httpResp, err = doWithRetries(c, req)
+	}
+
 	if err != nil {
-		return nil, err
+		bodyCancelFunc()
+		return nil, req.newRequestError(err)
 	}
 
+	duration := time.Since(start)
+	req.checkSlowResponse(duration)
+	req.checkLargeResponse(httpResp.ContentLength)
+
 	resp := NewResponse(c, req, httpResp)
+	resp.bodyCancelFunc = bodyCancelFunc
+	resp.duration = duration
 
-	// execute all afterDoFuncs
-	for _, afterDo := range req.afterDoFuncs {
+	if req.optDecompress {
+		if err = resp.decompress(); err != nil {
+			httpResp.Body.Close()
+			bodyCancelFunc()
+			return nil, err
+		}
+	}
+
+	// execute all afterDoFuncs, starting with the client ones
+	// the client slice is capped so appending to it always copies, rather than writing into its spare capacity
+	for _, afterDo := range append(cl.afterDoFuncs[:len(cl.afterDoFuncs):len(cl.afterDoFuncs)], req.afterDoFuncs...) {
 		if err = afterDo(req, resp); err != nil {
+			resp.Close()
 			return nil, err
 		}
 	}
@@ -106,17 +241,44 @@ func (cl *Client) Do(c context.Context, req *Request) (*Response, error) {
 
 func doWithRetries(c context.Context, req *Request) (*http.Response, error) {
 	reqc := req.request.WithContext(c)
-	if buf, ok := req.payload.(*bytes.Buffer); ok {
-		defer putBuffer(buf)
-	}
-	var httpResp *http.Response
-	var err error
+
+	// set once the WithRefreshOn401 func has run, so a repeated 401 is returned rather than refreshing again
+	var refreshed bool
 	for i := 1; ; i++ {
-		// run rate-limiting
-		req.client.rateLimit.limit(c)
+		// run rate-limiting, keeping track of the time spent throttled
+		throttled, err := req.client.rateLimitFor(reqc.URL).limit(c)
+		req.throttled += throttled
+		if err != nil {
+			// a streaming payload that was never sent would block its writer forever,
+			// while a buffered payload is kept for the fallback URLs and released by Do
+			if i == 1 {
+				req.closePayloadPipe()
+			}
+			return nil, err
+		}
+
+		// the body was consumed by the previous attempt, so replay it if possible
+		if (i > 1 || refreshed) && reqc.GetBody != nil {
+			if reqc.Body, err = reqc.GetBody(); err != nil {
+				return nil, err
+			}
+		}
 
 		req.debugf("request attempt #%d", i)
-		httpResp, err = req.client.client.Do(reqc)
+		req.setDeadlineHeader(c, reqc)
+		if req.nonceFunc != nil {
+			reqc.Header.Set(req.nonceHeader, req.nonceFunc())
+		}
+		req.dumpRequest(reqc)
+		httpResp, cancelAttempt, err := req.doAttempt(c, reqc)
+		req.dumpResponse(httpResp)
+		req.attempts++
+		record := AttemptRecord{Err: err}
+		if httpResp != nil {
+			req.lastStatus = httpResp.StatusCode
+			record.StatusCode = httpResp.StatusCode
+		}
+		req.attemptHistory = append(req.attemptHistory, record)
 		if err != nil && req.isErrBreaking(err) {
 			req.errorf("http.Client.Do err: %s | req: %s", err.Error(), req.String())
 			return nil, err
@@ -125,16 +287,37 @@ func doWithRetries(c context.Context, req *Request) (*http.Response, error) {
 		switch {
 		// returned when there is an underlying bad connection, so we want to retry as if it's a 500+ StatusCode
 		// NOTE: the io.EOF error will only be handled here if the WithRetryOnEOFError has been included with the Request
-		case err == io.EOF:
+		case errors.Is(err, io.EOF):
 			req.debugf("http.Client.Do returned io.EOF - request will retry | req: %s", req.String())
 
-		case err != nil && strings.Contains(err.Error(), "read: connection reset by peer"):
-			req.debugf("http.Client.Do returned 'read: connection reset by peer' - request will retry | req: %s", req.String())
+		// NOTE: connection resets are handled here unless WithRetryOnConnectionReset(false) has been included with the Request
+		case isConnectionReset(err):
+			req.debugf("http.Client.Do returned a connection reset - request will retry | req: %s", req.String())
+
+		// network timeouts (e.g. dial or TLS handshake) and attempt timeouts are transient, so we want to retry them as well
+		case err != nil:
+			req.debugf("http.Client.Do returned %s - request will retry | req: %s", err.Error(), req.String())
 
 		// if we used a multipart form, we need to check for an error from the goroutine
 		case i == 1 && req.optMultiPartForm && req.multiPartFormErr != nil:
 			return nil, req.multiPartFormErr
 
+		// refresh the credentials once on a 401, and resend without counting it as an attempt
+		case httpResp.StatusCode == http.StatusUnauthorized && req.refreshOn401 != nil && !refreshed:
+			req.debugf("status code 401, refreshing credentials and resending")
+			httpResp.Body.Close()
+			cancelAttempt()
+			refreshed = true
+			if err = req.refreshOn401(c); err != nil {
+				return nil, err
+			}
+			i--
+			continue
+
+		// the response asks to be retried with a WithRetryOnHeader header, whatever its status code
+		case req.hasRetryHeader(httpResp.Header):
+			req.debugf("status code %d with a retry header - request will retry | req: %s", httpResp.StatusCode, req.String())
+
 		// further attempts will be made only on 500+ status codes
 		// NOTE: the error returned from cl.client.Do(reqc) only contains scenarios regarding
 		// a bad request given, or a response with Location header missing or bad
@@ -151,6 +334,23 @@ func doWithRetries(c context.Context, req *Request) (*http.Response, error) {
 			return httpResp, err
 		}
 
+		// return resp and err if the context deadline would pass during the backoff delay,
+		// since there wouldn't be time left for another attempt
+		delay := req.backoffStrategy.waitDuration(i)
+		if deadline, ok := c.Deadline(); ok && time.Until(deadline) < delay {
+			req.debugf("context deadline leaves less than the %s backoff delay, exiting retry loop", delay)
+			return httpResp, err
+		}
+		req.attemptHistory[len(req.attemptHistory)-1].Delay = delay
+
+		if req.onRetryFunc != nil {
+			var resp *Response
+			if httpResp != nil {
+				resp = NewResponse(c, req, httpResp)
+			}
+			req.onRetryFunc(i, resp, err, delay)
+		}
+
 		if httpResp != nil {
 			// close the response body before we lose our reference to it
 			if err = httpResp.Body.Close(); err != nil {
@@ -158,16 +358,144 @@ func doWithRetries(c context.Context, req *Request) (*http.Response, error) {
 				return nil, err
 			}
 		}
+		cancelAttempt()
 
 		// wait before retrying, returning early if the context is cancelled
-		if err = req.waitForRetry(c, i); err != nil {
+		if err = req.waitForRetry(c, delay); err != nil {
 			return nil, err
 		}
 	}
 }
 
-func (req *Request) waitForRetry(c context.Context, i int) error {
-	delay := req.backoffStrategy.waitDuration(i)
+// doAttempt sends a single attempt of the Request, bounded by the WithAttemptTimeout duration if set
+// Only the wait for the response headers is bounded, so the body can still be read once it returns
+// The returned cancel func releases the attempt, for when its response is abandoned
+func (req *Request) doAttempt(c context.Context, reqc *http.Request) (*http.Response, context.CancelFunc, error) {
+	httpClient := req.client.httpClient(req.transportName)
+	if req.hedgeAfter > 0 && req.maxHedged > 0 {
+		return req.doHedgedAttempt(c, reqc, httpClient)
+	}
+	if req.attemptTimeout <= 0 {
+		httpResp, err := httpClient.Do(reqc)
+		return httpResp, func() {}, err
+	}
+
+	attemptC, cancel := context.WithCancel(c)
+	timer := time.AfterFunc(req.attemptTimeout, cancel)
+	httpResp, err := httpClient.Do(reqc.WithContext(attemptC))
+	if !timer.Stop() && err != nil && c.Err() == nil {
+		err = fmt.Errorf("%w after %s", ErrAttemptTimeout, req.attemptTimeout)
+	}
+	return httpResp, cancel, err
+}
+
+// hedgedResponse is the outcome of one of the requests sent by doHedgedAttempt
+type hedgedResponse struct {
+	index    int
+	httpResp *http.Response
+	err      error
+}
+
+// doHedgedAttempt sends the attempt, and a duplicate each time hedgeAfter passes without a response, up to maxHedged of them
+// The first response is returned with the cancel func of its request, and the others are cancelled and closed
+// If every request sent fails, the last error is returned
+func (req *Request) doHedgedAttempt(c context.Context, reqc *http.Request, httpClient *http.Client) (*http.Response, context.CancelFunc, error) {
+	// every request sends its own copy of the payload, since the losers can still be reading their bodies
+	// after Do has returned and released the pooled payload buffer
+	newHedge, canHedge, err := hedgeRequestFunc(reqc)
+	if err != nil {
+		return nil, func() {}, err
+	}
+
+	results := make(chan hedgedResponse, req.maxHedged+1)
+	var cancels []context.CancelFunc
+	send := func(r *http.Request) {
+		sendC, cancel := context.WithCancel(c)
+		index := len(cancels)
+		cancels = append(cancels, cancel)
+		go func() {
+			httpResp, err := httpClient.Do(r.WithContext(sendC))
+			results <- hedgedResponse{index: index, httpResp: httpResp, err: err}
+		}()
+	}
+
+	send(newHedge())
+	pending := 1
+	timer := time.NewTimer(req.hedgeAfter)
+	defer timer.Stop()
+	for {
+		select {
+		case result := <-results:
+			pending--
+			if result.err != nil {
+				cancels[result.index]()
+				if pending == 0 {
+					return nil, func() {}, result.err
+				}
+				continue
+			}
+
+			// cancel the other requests, then close any of their responses that arrive anyway
+			for i, cancel := range cancels {
+				if i != result.index {
+					cancel()
+				}
+			}
+			go func(pending int) {
+				for ; pending > 0; pending-- {
+					if loser := <-results; loser.httpResp != nil {
+						loser.httpResp.Body.Close()
+					}
+				}
+			}(pending)
+			return result.httpResp, cancels[result.index], nil
+
+		case <-timer.C:
+			if !canHedge || len(cancels) > req.maxHedged {
+				continue
+			}
+			req.debugf("no response after %s, sending hedged request #%d", req.hedgeAfter, len(cancels))
+			send(newHedge())
+			pending++
+			timer.Reset(req.hedgeAfter)
+		}
+	}
+}
+
+// hedgeRequestFunc returns a func making copies of reqc for doHedgedAttempt, each with its body read from a private copy of the payload
+// A body that can't be replayed is only sent once, so canHedge is false and the func returns reqc itself
+func hedgeRequestFunc(reqc *http.Request) (newHedge func() *http.Request, canHedge bool, err error) {
+	if reqc.Body == nil || reqc.Body == http.NoBody {
+		return func() *http.Request { return reqc.Clone(reqc.Context()) }, true, nil
+	}
+	if reqc.GetBody == nil {
+		return func() *http.Request { return reqc }, false, nil
+	}
+
+	body, err := reqc.GetBody()
+	if err != nil {
+		return nil, false, err
+	}
+	payload, err := ioutil.ReadAll(body)
+	body.Close()
+	if err != nil {
+		return nil, false, err
+	}
+	// reqc isn't sent, so release its body
+	reqc.Body.Close()
+
+	getBody := func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(payload)), nil
+	}
+	return func() *http.Request {
+		hedge := reqc.Clone(reqc.Context())
+		hedge.Body, _ = getBody()
+		hedge.GetBody = getBody
+		return hedge
+	}, true, nil
+}
+
+func (req *Request) waitForRetry(c context.Context, delay time.Duration) error {
 	req.debugf("waiting %s before next retry", delay)
 	select {
 	case <-time.After(delay):
@@ -186,7 +514,27 @@ func WithRequestOptions(opts []RequestOption) ClientOption {
 	}
 }
 
+// WithClientAfterDoFunc is a ClientOption that runs fn after every request the client makes, e.g. for logging or metrics
+// Client afterDoFuncs run in the order they're given, before any added to the request with WithAfterDoFunc,
+// and an error from any of them is returned by Do
+func WithClientAfterDoFunc(fn func(req *Request, resp *Response) error) ClientOption {
+	return func(c context.Context, cl *Client) error {
+		cl.afterDoFuncs = append(cl.afterDoFuncs, fn)
+		return nil
+	}
+}
+
+// WithClientDefaultAccept is a ClientOption that sets the Accept header to contentType
+// on every request built by the client that doesn't set its own Accept header
+func WithClientDefaultAccept(contentType string) ClientOption {
+	return func(c context.Context, cl *Client) error {
+		cl.defaultAccept = contentType
+		return nil
+	}
+}
+
 // Get is a helper func for Do, setting the Method internally
+// A payload is sent as the GET body, for APIs (e.g. Elasticsearch) that expect one
 func (cl *Client) Get(c context.Context, url string, opts ...RequestOption) (*Response, error) {
 	req, err := cl.NewRequest(c, http.MethodGet, url, opts...)
 	if err != nil {
@@ -240,6 +588,93 @@ func (cl *Client) Delete(c context.Context, url string, opts ...RequestOption) (
 	return cl.Do(c, req)
 }
 
+// Options is a helper func for Do, setting the Method internally
+func (cl *Client) Options(c context.Context, url string, opts ...RequestOption) (*Response, error) {
+	req, err := cl.NewRequest(c, http.MethodOptions, url, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return cl.Do(c, req)
+}
+
+// Method is a helper func for Do, using the given method
+// This allows for non-standard methods, such as the WebDAV PROPFIND
+func (cl *Client) Method(c context.Context, method, url string, opts ...RequestOption) (*Response, error) {
+	req, err := cl.NewRequest(c, method, url, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return cl.Do(c, req)
+}
+
+// Paginate GETs url and each following page, calling pageFn with every page and then nextFn for the url of the next one,
+// until nextFn returns "" or either func returns an error
+// A relative next url is resolved against the url of the page, and the opts are used for every page,
+// so params that the next urls already carry shouldn't be given with WithParam
+// pageFn typically decodes the page, so nextFn can return a next url decoded from the body, or read it from a header,
+// e.g. resp.Links()["next"]
+// Each Response is closed once both funcs have run, and the context is checked before each page is requested
+func (cl *Client) Paginate(c context.Context, url string, nextFn func(resp *Response) (string, error), pageFn func(resp *Response) error, opts ...RequestOption) error {
+	for url != "" {
+		if err := c.Err(); err != nil {
+			return err
+		}
+		resp, err := cl.Get(c, url, opts...)
+		if err != nil {
+			return err
+		}
+		url, err = paginatePage(resp, nextFn, pageFn)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// paginatePage runs the Paginate funcs on resp, and returns the resolved url of the next page
+func paginatePage(resp *Response, nextFn func(resp *Response) (string, error), pageFn func(resp *Response) error) (string, error) {
+	defer resp.Close()
+	if err := pageFn(resp); err != nil {
+		return "", err
+	}
+	next, err := nextFn(resp)
+	if err != nil || next == "" {
+		return "", err
+	}
+	nextURL, err := resp.FinalURL().Parse(next)
+	if err != nil {
+		return "", err
+	}
+	return nextURL.String(), nil
+}
+
+// Warmup pre-populates the connection pool by making conns concurrent HEAD requests to url
+// and releasing their connections as idle, so the first real requests skip the dial and TLS handshake
+// Only up to WithMaxIdleConnsPerHost connections are kept idle
+func (cl *Client) Warmup(c context.Context, url string, conns int) error {
+	errs := make(chan error, conns)
+	var wg sync.WaitGroup
+	for i := 0; i < conns; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := cl.Head(c, url)
+			if err != nil {
+				errs <- err
+				return
+			}
+			resp.Close()
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	if err, ok := <-errs; ok {
+		return fmt.Errorf("warmup of %s failed: %w", url, err)
+	}
+	return nil
+}
+
 // ClientOption is a func to configure optional Client settings
 type ClientOption func(c context.Context, cl *Client) error
 
@@ -267,26 +702,382 @@ func WithMaxIdleConnsPerHost(maxConns int) ClientOption {
 	}
 }
 
-// WithRateLimit is a ClientOption that sets the cl.rateLimitting up for this client
-func WithRateLimit(rate int, dur time.Duration) ClientOption {
+// WithMaxRedirects is a ClientOption that sets the cl.maxRedirects field to the given int
+// Like the http.Client default policy, the request errors once maxRedirects consecutive requests have been made
+// Values less than 1 leave the http.Client default of 10 in place
+func WithMaxRedirects(maxRedirects int) ClientOption {
+	return func(c context.Context, cl *Client) error {
+		cl.maxRedirects = maxRedirects
+		return nil
+	}
+}
+
+// WithPreserveAuthOnRedirect is a ClientOption that keeps the Authorization header when following a redirect to the same host,
+// including an upgrade from http to https, in case the http.Client stripped it
+// The header is removed for any other redirect, so it's never sent to a different host or port, or downgraded from https to http
+func WithPreserveAuthOnRedirect() ClientOption {
+	return func(c context.Context, cl *Client) error {
+		cl.preserveAuthOnRedirect = true
+		return nil
+	}
+}
+
+// WithReadTimeout is a ClientOption that fails a request when a single read from its connection takes longer than d,
+// so a server that stalls or trickles its response is caught long before the overall request deadline
+// The deadline is reset before every read, and idle connections that exceed it are closed rather than reused
+func WithReadTimeout(d time.Duration) ClientOption {
+	return func(c context.Context, cl *Client) error {
+		cl.readTimeout = d
+		return nil
+	}
+}
+
+// WithDisableCompression is a ClientOption that stops the http.Transport from adding Accept-Encoding: gzip
+// and transparently decompressing the response, so Bytes returns the body exactly as the server sent it
+// Use WithDecompression on a request to decompress it explicitly instead
+func WithDisableCompression() ClientOption {
+	return func(c context.Context, cl *Client) error {
+		cl.disableCompression = true
+		return nil
+	}
+}
+
+const (
+	// defaultDrainOnClose is the largest unread body Response.Close drains by default
+	defaultDrainOnClose = 256 << 10
+
+	// drainOnCloseTimeout bounds the time Response.Close spends draining, so a slow server never stalls it
+	drainOnCloseTimeout = 50 * time.Millisecond
+
+	// defaultMaxRedirects matches the http.Client default redirect policy
+	defaultMaxRedirects = 10
+)
+
+// WithDrainOnClose is a ClientOption that sets the largest unread body Response.Close reads and discards
+// so the connection can be reused, instead of closing it. It defaults to 256KB, and 0 disables draining
+// Only bodies with a known Content-Length of at most maxBytes are drained, and draining gives up after 50ms
+func WithDrainOnClose(maxBytes int64) ClientOption {
+	return func(c context.Context, cl *Client) error {
+		cl.drainOnClose = maxBytes
+		return nil
+	}
+}
+
+// WithMaxResponseHeaderBytes is a ClientOption that fails any request whose response headers are larger than n bytes,
+// protecting against servers sending enormous headers. Values less than 1 leave the http.Transport default in place
+func WithMaxResponseHeaderBytes(n int64) ClientOption {
+	return func(c context.Context, cl *Client) error {
+		cl.maxResponseHeaderBytes = n
+		return nil
+	}
+}
+
+// WithClientTransports is a ClientOption that registers named transports, which requests select with WithTransportName,
+// so upstreams needing different connection settings (e.g. HTTP/1 only or mTLS) can share one Client
+// Named transports are used as-is, so the transport settings of the other ClientOptions don't apply to them
+func WithClientTransports(transports map[string]http.RoundTripper) ClientOption {
+	return func(c context.Context, cl *Client) error {
+		if cl.transports == nil {
+			cl.transports = make(map[string]http.RoundTripper, len(transports))
+		}
+		for name, transport := range transports {
+			if name == "" || transport == nil {
+				return fmt.Errorf("invalid transport %q", name)
+			}
+			cl.transports[name] = transport
+		}
+		return nil
+	}
+}
+
+// WithClientMaxResponseBytes is a ClientOption that fails reading any response body larger than n bytes with ErrResponseTooLarge,
+// capping the memory a single response can use in Bytes, Decode or Pipe. A decompressed body is limited after decompression too
+func WithClientMaxResponseBytes(n int64) ClientOption {
+	return func(c context.Context, cl *Client) error {
+		cl.maxResponseBytes = n
+		return nil
+	}
+}
+
+// WithLocalAddr is a ClientOption that makes outgoing connections from addr, e.g. to pick the source IP on a multi-homed host
+// addr is usually a *net.TCPAddr with a zero Port, so each connection still gets its own port
+func WithLocalAddr(addr net.Addr) ClientOption {
+	return func(c context.Context, cl *Client) error {
+		cl.localAddr = addr
+		return nil
+	}
+}
+
+// WithTLSClientCert is a ClientOption that presents the PEM encoded certificate and key to servers requesting a client certificate (mTLS)
+// It can be given more than once, and the first certificate acceptable to the server is presented
+func WithTLSClientCert(certPEM, keyPEM []byte) ClientOption {
+	return func(c context.Context, cl *Client) error {
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return fmt.Errorf("invalid TLS client certificate: %w", err)
+		}
+		cl.tlsClientCerts = append(cl.tlsClientCerts, cert)
+		return nil
+	}
+}
+
+// WithALPN is a ClientOption that offers the protocols, in order of preference, for ALPN negotiation in the TLS handshake,
+// e.g. for services that select a backend by a custom ALPN token
+// The protocols are set as the NextProtos of the transport's own tls.Config (shared with WithTLSClientCert),
+// which turns off the transport's automatic HTTP/2, so requests are sent as HTTP/1.1 whatever protocol is negotiated
+// h2 is rejected with an error, since a server selecting it would then be sent HTTP/1.1
+func WithALPN(protocols ...string) ClientOption {
+	return func(c context.Context, cl *Client) error {
+		for _, protocol := range protocols {
+			if protocol == "h2" {
+				return errors.New("ALPN protocol h2 isn't supported, requests are sent as HTTP/1.1")
+			}
+		}
+		cl.alpnProtocols = protocols
+		return nil
+	}
+}
+
+// WithResolveHost is a ClientOption that pins host to ip when dialing, like curl's --resolve
+// host can optionally include a port to only pin connections to that port
+// The request URL, Host header and TLS server name are unchanged
+func WithResolveHost(host, ip string) ClientOption {
+	return func(c context.Context, cl *Client) error {
+		if net.ParseIP(ip) == nil {
+			return fmt.Errorf("invalid ip %q for host %q", ip, host)
+		}
+		if cl.resolveHosts == nil {
+			cl.resolveHosts = map[string]string{}
+		}
+		cl.resolveHosts[host] = ip
+		return nil
+	}
+}
+
+// WithRateLimit is a ClientOption that limits the client to rate requests per dur,
+// allowing bursts of up to burst requests once the client has been idle
+// useJitter spreads throttled requests randomly +/- 33% of their spacing, so many clients started at once
+// don't send in lockstep. The mean rate is unchanged
+func WithRateLimit(rate int, dur time.Duration, burst int, useJitter bool) ClientOption {
+	return func(c context.Context, cl *Client) error {
+		cl.rateLimit = newRateLimit(rate, dur, burst, useJitter)
+		return nil
+	}
+}
+
+// WithMaxConcurrentRequests is a ClientOption that caps the number of requests the client has in flight at once
+// Do blocks until one of the n requests in flight returns, or its context is done
+// NOTE: a request stops counting once Do returns, so reading its body isn't counted
+func WithMaxConcurrentRequests(n int) ClientOption {
 	return func(c context.Context, cl *Client) error {
-		cl.rateLimit = newRateLimit(rate, dur)
+		if n > 0 {
+			cl.requestSem = make(chan struct{}, n)
+		}
 		return nil
 	}
 }
 
+// WithPerHostRateLimit is a ClientOption that limits requests to each host in limits separately
+// Hosts are matched with their port first, then without it, e.g. "api.example.com:8443" or "api.example.com"
+// Requests to any other host use the WithRateLimit limit, if one is set
+func WithPerHostRateLimit(limits map[string]RateLimitSpec) ClientOption {
+	return func(c context.Context, cl *Client) error {
+		cl.hostRateLimits = newHostRateLimits(limits)
+		return nil
+	}
+}
+
+// rateLimitFor returns the rate limit for the host of u, falling back to the client rate limit
+func (cl *Client) rateLimitFor(u *url.URL) *rateLimit {
+	if cl.hostRateLimits != nil {
+		if rl, ok := cl.hostRateLimits.get(u); ok {
+			return rl
+		}
+	}
+	return &cl.rateLimit
+}
+
 // setClient creates the standard http.Client using the settings in the given Client
 func (cl *Client) setClient() {
+	cl.transport = &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: cl.readTimeoutDialContext(cl.dialContext((&net.Dialer{
+			KeepAlive: cl.keepAlive,
+			LocalAddr: cl.localAddr,
+		}).DialContext)),
+		TLSHandshakeTimeout:    cl.handshakeTimeout,
+		MaxIdleConnsPerHost:    cl.maxIdleConnsPerHost,
+		DisableCompression:     cl.disableCompression,
+		MaxResponseHeaderBytes: cl.maxResponseHeaderBytes,
+	}
+	// a custom TLSClientConfig stops the transport attempting HTTP/2, which is why WithALPN rejects h2
+	if len(cl.tlsClientCerts) > 0 || len(cl.alpnProtocols) > 0 {
+		cl.transport.TLSClientConfig = &tls.Config{
+			Certificates: cl.tlsClientCerts,
+			NextProtos:   cl.alpnProtocols,
+		}
+	}
+	var transport http.RoundTripper = cl.transport
+
+	// the cassette sits under the tracing transport, so replayed requests are still traced
+	if cl.cassette != nil {
+		cl.cassette.base = transport
+		cl.cassette.matcher = cl.cassetteMatcher
+		if cl.cassette.matcher == nil {
+			cl.cassette.matcher = DefaultCassetteMatcher
+		}
+		transport = cl.cassette
+	}
+
+	// coalesced requests are sent (and recorded) once
+	if cl.coalesceWindow > 0 {
+		transport = &coalesceTransport{
+			base:   transport,
+			window: cl.coalesceWindow,
+			calls:  map[string]*coalescedCall{},
+		}
+	}
+
 	cl.client = &http.Client{
 		Transport: &ochttp.Transport{
-			Base: &http.Transport{
-				Proxy: http.ProxyFromEnvironment,
-				Dial: (&net.Dialer{
-					KeepAlive: cl.keepAlive,
-				}).Dial,
-				TLSHandshakeTimeout: cl.handshakeTimeout,
-				MaxIdleConnsPerHost: cl.maxIdleConnsPerHost,
-			},
+			Base: transport,
 		},
 	}
+
+	if cl.maxRedirects > 0 || cl.preserveAuthOnRedirect {
+		cl.client.CheckRedirect = cl.checkRedirect
+	}
+
+	// named transports share the redirect policy and tracing of the default one
+	if len(cl.transports) == 0 {
+		return
+	}
+	cl.namedClients = make(map[string]*http.Client, len(cl.transports))
+	for name, transport := range cl.transports {
+		cl.namedClients[name] = &http.Client{
+			Transport: &ochttp.Transport{
+				Base: transport,
+			},
+			CheckRedirect: cl.client.CheckRedirect,
+		}
+	}
+}
+
+// CloseIdleConnections closes the idle connections of the client transports, including those registered with WithClientTransports
+// Connections in use aren't interrupted, so call it when rotating a Client to release its connections once it's done
+func (cl *Client) CloseIdleConnections() {
+	if cl.transport != nil {
+		cl.transport.CloseIdleConnections()
+	}
+	type closeIdler interface {
+		CloseIdleConnections()
+	}
+	for _, transport := range cl.transports {
+		if ci, ok := transport.(closeIdler); ok {
+			ci.CloseIdleConnections()
+		}
+	}
+}
+
+// httpClient returns the http.Client for the transport registered as name, or the default one if name is empty
+func (cl *Client) httpClient(name string) *http.Client {
+	if name == "" {
+		return cl.client
+	}
+	return cl.namedClients[name]
+}
+
+// dialContext wraps dial, rewriting the address of hosts pinned through WithResolveHost
+func (cl *Client) dialContext(dial func(c context.Context, network, addr string) (net.Conn, error)) func(c context.Context, network, addr string) (net.Conn, error) {
+	if len(cl.resolveHosts) == 0 {
+		return dial
+	}
+	return func(c context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return dial(c, network, addr)
+		}
+		ip, ok := cl.resolveHosts[addr]
+		if !ok {
+			ip, ok = cl.resolveHosts[host]
+		}
+		if ok {
+			addr = net.JoinHostPort(ip, port)
+		}
+		return dial(c, network, addr)
+	}
+}
+
+// readTimeoutDialContext wraps dial, setting a read deadline on the connection before each read
+func (cl *Client) readTimeoutDialContext(dial func(c context.Context, network, addr string) (net.Conn, error)) func(c context.Context, network, addr string) (net.Conn, error) {
+	if cl.readTimeout <= 0 {
+		return dial
+	}
+	return func(c context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(c, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &readTimeoutConn{Conn: conn, readTimeout: cl.readTimeout}, nil
+	}
+}
+
+// readTimeoutConn is a net.Conn that fails any read that takes longer than readTimeout
+type readTimeoutConn struct {
+	net.Conn
+	readTimeout time.Duration
+}
+
+func (conn *readTimeoutConn) Read(b []byte) (int, error) {
+	if err := conn.Conn.SetReadDeadline(time.Now().Add(conn.readTimeout)); err != nil {
+		return 0, err
+	}
+	return conn.Conn.Read(b)
+}
+
+// checkRedirect enforces the redirect policy configured by the ClientOptions
+func (cl *Client) checkRedirect(req *http.Request, via []*http.Request) error {
+	maxRedirects := cl.maxRedirects
+	if maxRedirects < 1 {
+		maxRedirects = defaultMaxRedirects
+	}
+	if len(via) >= maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxRedirects)
+	}
+
+	// the http.Client copies the headers of the first request, so that's where the Authorization header came from
+	// it only compares hostnames, so the header is removed here for a different port or an https to http downgrade
+	if cl.preserveAuthOnRedirect {
+		if !isSameHostRedirect(via[0].URL, req.URL) {
+			req.Header.Del(AuthorizationHeader)
+		} else if auth := via[0].Header.Get(AuthorizationHeader); auth != "" {
+			req.Header.Set(AuthorizationHeader, auth)
+		}
+	}
+	return nil
+}
+
+// isSameHostRedirect reports whether credentials sent to from can be sent to to:
+// the hostname must match, and either the port matches or the scheme is upgraded from http to https on the default ports
+// A redirect from https to http is never the same host, so credentials aren't sent in the clear
+func isSameHostRedirect(from, to *url.URL) bool {
+	if !strings.EqualFold(from.Hostname(), to.Hostname()) {
+		return false
+	}
+	if strings.EqualFold(from.Scheme, "https") && !strings.EqualFold(to.Scheme, "https") {
+		return false
+	}
+	fromPort, toPort := urlPort(from), urlPort(to)
+	return fromPort == toPort || (fromPort == "80" && toPort == "443")
+}
+
+// urlPort returns the port of u, defaulting to the port of its scheme
+func urlPort(u *url.URL) string {
+	if port := u.Port(); port != "" {
+		return port
+	}
+	if strings.EqualFold(u.Scheme, "https") {
+		return "443"
+	}
+	return "80"
 }
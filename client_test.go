@@ -32,6 +32,7 @@ func TestNewClient(t *testing.T) {
 				keepAlive:           15 * time.Second,
 				handshakeTimeout:    30 * time.Second,
 				maxIdleConnsPerHost: 20,
+				drainOnClose:        defaultDrainOnClose,
 			},
 			false,
 		},
@@ -43,7 +44,8 @@ func TestNewClient(t *testing.T) {
 				t.Errorf("NewClient() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			got.client = nil // not comparing the *http.Client, just the *Client
+			got.client = nil    // not comparing the *http.Client, just the *Client
+			got.transport = nil // nor the *http.Transport under it
 			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("NewClient() = %v, want %v", got, tt.want)
 			}
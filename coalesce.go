@@ -0,0 +1,113 @@
+package fetcher
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// WithCoalesce is a ClientOption that holds each request for window before sending it,
+// merging identical requests (same method, url and body) that arrive in the meantime into the one upstream call,
+// e.g. to debounce bursts of identical events. Every merged request gets a copy of the same response
+// NOTE: only use it where sending a duplicate once is correct, since:
+//   - every request is delayed by up to window, even if no duplicate arrives
+//   - headers aren't compared, so requests differing only in headers (e.g. Authorization) are merged
+//   - the context of the first request is used to send it, so cancelling it fails the merged requests
+//   - each response body is buffered in memory, to be copied to every merged request
+func WithCoalesce(window time.Duration) ClientOption {
+	return func(c context.Context, cl *Client) error {
+		cl.coalesceWindow = window
+		return nil
+	}
+}
+
+// coalesceTransport is an http.RoundTripper that merges identical requests arriving within window of each other
+type coalesceTransport struct {
+	base   http.RoundTripper
+	window time.Duration
+
+	mu sync.Mutex
+	// held requests by their coalesceKey, removed once sent
+	calls map[string]*coalescedCall
+}
+
+// coalescedCall is a held request, and its response once sent
+type coalescedCall struct {
+	done chan struct{}
+	resp *http.Response
+	body []byte
+	err  error
+}
+
+// RoundTrip implements http.RoundTripper
+func (ct *coalesceTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	var body []byte
+	if r.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		// a RoundTripper mustn't modify the request, so send a copy with the buffered body
+		r = r.Clone(r.Context())
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	key := r.Method + " " + r.URL.String() + "\n" + string(body)
+	ct.mu.Lock()
+	call, ok := ct.calls[key]
+	if !ok {
+		call = &coalescedCall{done: make(chan struct{})}
+		ct.calls[key] = call
+	}
+	ct.mu.Unlock()
+
+	if !ok {
+		ct.send(key, call, r)
+	}
+
+	select {
+	case <-call.done:
+	case <-r.Context().Done():
+		return nil, r.Context().Err()
+	}
+	if call.err != nil {
+		return nil, call.err
+	}
+	resp := *call.resp
+	resp.Header = call.resp.Header.Clone()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(call.body))
+	resp.Request = r
+	return &resp, nil
+}
+
+// send holds r for the window, then sends it and buffers the response for every request merged into call
+func (ct *coalesceTransport) send(key string, call *coalescedCall, r *http.Request) {
+	defer close(call.done)
+
+	timer := time.NewTimer(ct.window)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-r.Context().Done():
+	}
+
+	// stop merging requests into the call once it's sent
+	ct.mu.Lock()
+	delete(ct.calls, key)
+	ct.mu.Unlock()
+
+	if call.err = r.Context().Err(); call.err != nil {
+		return
+	}
+	call.resp, call.err = ct.base.RoundTrip(r)
+	if call.err != nil {
+		return
+	}
+	call.body, call.err = ioutil.ReadAll(call.resp.Body)
+	call.resp.Body.Close()
+}
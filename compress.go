@@ -0,0 +1,208 @@
+package fetcher
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// AcceptEncodingHeader = "Accept-Encoding"
+	AcceptEncodingHeader = "Accept-Encoding"
+
+	// ContentEncodingHeader = "Content-Encoding"
+	ContentEncodingHeader = "Content-Encoding"
+)
+
+// Compressor wraps a request payload writer with a writer that compresses it
+type Compressor func(w io.Writer) (io.WriteCloser, error)
+
+// Decompressor wraps a compressed response body with a reader that decompresses it
+// A reader that also implements io.Closer is closed with the Response, so it can release any resources it holds
+type Decompressor func(r io.Reader) (io.Reader, error)
+
+var (
+	compressorsMu sync.RWMutex
+	compressors   = map[string]Compressor{
+		"gzip":    gzipCompressor,
+		"deflate": deflateCompressor,
+	}
+
+	decompressorsMu sync.RWMutex
+	decompressors   = map[string]Decompressor{
+		"gzip":    gzipDecompressor,
+		"deflate": deflateDecompressor,
+	}
+)
+
+// RegisterCompressor makes the Compressor available to WithCompressedPayload for the given Content-Encoding
+// gzip and deflate are registered by default, other encodings are registered by their subpackages (e.g. fetcher/zstd)
+func RegisterCompressor(encoding string, compressor Compressor) {
+	compressorsMu.Lock()
+	defer compressorsMu.Unlock()
+	compressors[strings.ToLower(encoding)] = compressor
+}
+
+// getCompressor returns the registered Compressor for the given Content-Encoding
+func getCompressor(encoding string) (Compressor, bool) {
+	compressorsMu.RLock()
+	defer compressorsMu.RUnlock()
+	compressor, ok := compressors[strings.ToLower(encoding)]
+	return compressor, ok
+}
+
+// RegisterDecompressor makes the Decompressor available to WithDecompression for the given Content-Encoding
+// gzip and deflate are registered by default, other encodings are registered by their subpackages (e.g. fetcher/brotli)
+func RegisterDecompressor(encoding string, decompressor Decompressor) {
+	decompressorsMu.Lock()
+	defer decompressorsMu.Unlock()
+	decompressors[strings.ToLower(encoding)] = decompressor
+}
+
+// getDecompressor returns the registered Decompressor for the given Content-Encoding
+func getDecompressor(encoding string) (Decompressor, bool) {
+	decompressorsMu.RLock()
+	defer decompressorsMu.RUnlock()
+	decompressor, ok := decompressors[strings.ToLower(strings.TrimSpace(encoding))]
+	return decompressor, ok
+}
+
+// registeredEncodings returns the sorted names of all registered Decompressors
+func registeredEncodings() []string {
+	decompressorsMu.RLock()
+	defer decompressorsMu.RUnlock()
+	encodings := make([]string, 0, len(decompressors))
+	for encoding := range decompressors {
+		encodings = append(encodings, encoding)
+	}
+	sort.Strings(encodings)
+	return encodings
+}
+
+func gzipCompressor(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriter(w), nil
+}
+
+func deflateCompressor(w io.Writer) (io.WriteCloser, error) {
+	return flate.NewWriter(w, flate.DefaultCompression)
+}
+
+func gzipDecompressor(r io.Reader) (io.Reader, error) {
+	return gzip.NewReader(r)
+}
+
+func deflateDecompressor(r io.Reader) (io.Reader, error) {
+	return flate.NewReader(r), nil
+}
+
+// WithCompressedPayload compresses the Request payload with the registered Compressor for the given encoding
+// and sets the Content-Encoding header
+// The payload is compressed once all RequestOptions have run, so option order doesn't matter
+func WithCompressedPayload(encoding string) RequestOption {
+	return func(c context.Context, req *Request) error {
+		if _, ok := getCompressor(encoding); !ok {
+			return fmt.Errorf("no compressor registered for %s", encoding)
+		}
+		req.payloadEncoding = encoding
+		return nil
+	}
+}
+
+// WithGzipPayload gzip compresses the Request payload and sets the Content-Encoding header to gzip
+func WithGzipPayload() RequestOption {
+	return WithCompressedPayload("gzip")
+}
+
+// compressPayload replaces req.payload with a buffer holding its compressed bytes
+func (req *Request) compressPayload() error {
+	if req.payloadEncoding == "" || req.payload == nil {
+		return nil
+	}
+
+	compressor, ok := getCompressor(req.payloadEncoding)
+	if !ok {
+		return fmt.Errorf("no compressor registered for %s", req.payloadEncoding)
+	}
+
+	buf := getBuffer()
+	cw, err := compressor(buf)
+	if err != nil {
+		putBuffer(buf)
+		return err
+	}
+	if _, err = io.Copy(cw, req.payload); err != nil {
+		putBuffer(buf)
+		return err
+	}
+	if err = cw.Close(); err != nil {
+		putBuffer(buf)
+		return err
+	}
+
+	req.setPayloadBuffer(buf)
+	req.headers = append(req.headers, newHeader(ContentEncodingHeader, req.payloadEncoding))
+	return nil
+}
+
+// WithDecompression sets the Accept-Encoding header to the given encodings (or all registered encodings if none are given)
+// and decompresses the Response body based on its Content-Encoding header
+// The Content-Length header of a decompressed Response is removed, and its size is reported by resp.UncompressedSize
+// NOTE: setting Accept-Encoding disables the transparent gzip handling of the http.Transport
+func WithDecompression(encodings ...string) RequestOption {
+	return func(c context.Context, req *Request) error {
+		if len(encodings) == 0 {
+			encodings = registeredEncodings()
+		}
+		req.headers = append(req.headers, newHeader(AcceptEncodingHeader, strings.Join(encodings, ", ")))
+		req.optDecompress = true
+		return nil
+	}
+}
+
+// decompress wraps resp.body with the registered Decompressor matching the Content-Encoding header
+// Multiple encodings are removed in the reverse order they were applied
+func (resp *Response) decompress() error {
+	contentEncoding := resp.response.Header.Get(ContentEncodingHeader)
+	if contentEncoding == "" {
+		return nil
+	}
+
+	// resolve every encoding before wrapping, so the body is never left partially decompressed
+	encodings := strings.Split(contentEncoding, ",")
+	decompressorChain := make([]Decompressor, 0, len(encodings))
+	for i := len(encodings) - 1; i >= 0; i-- {
+		if strings.EqualFold(strings.TrimSpace(encodings[i]), "identity") {
+			continue
+		}
+		decompressor, ok := getDecompressor(encodings[i])
+		if !ok {
+			resp.request.debugf("no decompressor registered for %s, leaving body compressed", contentEncoding)
+			return nil
+		}
+		decompressorChain = append(decompressorChain, decompressor)
+	}
+
+	for _, decompressor := range decompressorChain {
+		body, err := decompressor(resp.body)
+		if err != nil {
+			return err
+		}
+		resp.body = body
+		if closer, ok := body.(io.Closer); ok {
+			resp.decompressors = append(resp.decompressors, closer)
+		}
+	}
+
+	// the Content-Length is the compressed size, so drop it like the http.Transport does, see UncompressedSize
+	resp.decompressed = true
+	resp.response.Header.Del("Content-Length")
+	resp.countUncompressed()
+	resp.limitBody()
+	resp.request.debugf("%s content-encoding decompressed", contentEncoding)
+	return nil
+}
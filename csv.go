@@ -0,0 +1,104 @@
+package fetcher
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+func csvDecodeFunc(r io.Reader, v interface{}) error {
+	return newCSVDecodeFunc(',')(r, v)
+}
+
+// newCSVDecodeFunc returns a DecodeFunc reading fields separated by comma
+func newCSVDecodeFunc(comma rune) DecodeFunc {
+	return func(r io.Reader, v interface{}) error {
+		cr := csv.NewReader(r)
+		cr.Comma = comma
+		records, err := cr.ReadAll()
+		if err != nil {
+			return err
+		}
+
+		if dst, ok := v.(*[][]string); ok {
+			*dst = records
+			return nil
+		}
+
+		return decodeCSVRecords(records, v)
+	}
+}
+
+// decodeCSVRecords maps the header row of records to the `csv` tags (or names) of the struct fields
+// and appends a struct to the slice v points to for every following row
+func decodeCSVRecords(records [][]string, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("csv: decode target must be a *[][]string or a pointer to a slice of structs, got %T", v)
+	}
+
+	slice := rv.Elem()
+	elemType := slice.Type().Elem()
+	structType := elemType
+	if elemType.Kind() == reflect.Ptr {
+		structType = elemType.Elem()
+	}
+	if structType.Kind() != reflect.Struct {
+		return fmt.Errorf("csv: decode target must be a *[][]string or a pointer to a slice of structs, got %T", v)
+	}
+
+	out := reflect.MakeSlice(slice.Type(), 0, len(records))
+	if len(records) == 0 {
+		slice.Set(out)
+		return nil
+	}
+
+	// map each header column to a struct field index, -1 when no field matches
+	columns := make([]int, len(records[0]))
+	for i, name := range records[0] {
+		columns[i] = csvFieldIndex(structType, strings.TrimSpace(name))
+	}
+
+	for row, record := range records[1:] {
+		elem := reflect.New(structType).Elem()
+		for col, value := range record {
+			if col >= len(columns) || columns[col] == -1 {
+				continue
+			}
+			if err := setFieldFromString(elem.Field(columns[col]), value); err != nil {
+				return fmt.Errorf("csv: row %d, column %q: %s", row+1, records[0][col], err)
+			}
+		}
+		if elemType.Kind() == reflect.Ptr {
+			elem = elem.Addr()
+		}
+		out = reflect.Append(out, elem)
+	}
+
+	slice.Set(out)
+	return nil
+}
+
+// csvFieldIndex returns the index of the exported field tagged (or named) name, or -1 if there isn't one
+func csvFieldIndex(structType reflect.Type, name string) int {
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		tag := strings.Split(field.Tag.Get("csv"), ",")[0]
+		switch {
+		case tag == "-":
+			continue
+		case tag != "":
+			if tag == name {
+				return i
+			}
+		case strings.EqualFold(field.Name, name):
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,79 @@
+package fetcher
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+type csvTestObject struct {
+	URL     string `csv:"url"`
+	Count   int    `csv:"count"`
+	Active  bool
+	Ignored string `csv:"-"`
+}
+
+func TestCSVDecode(t *testing.T) {
+	tests := []struct {
+		name          string
+		body          string
+		decodeOptions []DecodeOption
+		got           interface{}
+		want          interface{}
+	}{
+		{
+			"detected into records",
+			"url,count\nhttps://nozzle.io/,30\n\"https://nozzle.io/a,b\",31\n",
+			[]DecodeOption{},
+			&[][]string{},
+			&[][]string{{"url", "count"}, {"https://nozzle.io/", "30"}, {"https://nozzle.io/a,b", "31"}},
+		},
+		{
+			"detected into structs",
+			"count,url,active,Ignored\n30,https://nozzle.io/,true,skipped\n31,\"https://nozzle.io/a,b\",false,skipped\n",
+			[]DecodeOption{},
+			&[]csvTestObject{},
+			&[]csvTestObject{
+				{URL: "https://nozzle.io/", Count: 30, Active: true},
+				{URL: "https://nozzle.io/a,b", Count: 31},
+			},
+		},
+		{
+			"custom delimiter into struct pointers",
+			"url;count\nhttps://nozzle.io/;30\n",
+			[]DecodeOption{WithCSVBody(';')},
+			&[]*csvTestObject{},
+			&[]*csvTestObject{{URL: "https://nozzle.io/", Count: 30}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := context.Background()
+			ts := testServerHelper(t, &serverData{
+				headers:    map[string]string{ContentTypeHeader: ContentTypeCSV + "; charset=utf-8"},
+				body:       []byte(tt.body),
+				statusCode: http.StatusOK,
+			})
+			defer ts.Close()
+
+			cl, err := NewClient(c)
+			if err != nil {
+				t.Fatalf("NewClient failed: %v", err)
+			}
+
+			resp, err := cl.Get(c, ts.URL)
+			if err != nil {
+				t.Fatalf("cl.Get failed: %v", err)
+			}
+
+			if err = resp.Decode(c, tt.got, tt.decodeOptions...); err != nil {
+				t.Fatalf("resp.Decode failed: %v", err)
+			}
+
+			if !reflect.DeepEqual(tt.got, tt.want) {
+				t.Errorf("got = %v, want %v", tt.got, tt.want)
+			}
+		})
+	}
+}
@@ -1,20 +1,81 @@
 package fetcher
 
 import (
+	"bytes"
 	"context"
+	"encoding"
 	"encoding/gob"
 	"encoding/json"
 	"encoding/xml"
+	"errors"
 	"io"
+	"mime"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 )
 
 // DecodeFunc allows users to provide a custom decoder to use with Decode
 type DecodeFunc func(io.Reader, interface{}) error
 
+var (
+	decodersMu sync.RWMutex
+	decoders   = map[string]DecodeFunc{
+		ContentTypeJSON:       jsonDecodeFunc,
+		ContentTypeGob:        gobDecodeFunc,
+		ContentTypeXML:        xmlDecodeFunc,
+		ContentTypeCSV:        csvDecodeFunc,
+		ContentTypeURLEncoded: formDecodeFunc,
+	}
+)
+
+// RegisterDecoder makes the DecodeFunc available to Decode's auto-detection for the given Content-Type
+// JSON, Gob, XML, CSV and URL encoded form values are registered by default
+func RegisterDecoder(contentType string, decodeFunc DecodeFunc) {
+	decodersMu.Lock()
+	defer decodersMu.Unlock()
+	decoders[normalizeContentType(contentType)] = decodeFunc
+}
+
+// getDecoder returns the registered DecodeFunc for the given Content-Type
+func getDecoder(contentType string) (DecodeFunc, bool) {
+	decodersMu.RLock()
+	defer decodersMu.RUnlock()
+	decodeFunc, ok := decoders[normalizeContentType(contentType)]
+	return decodeFunc, ok
+}
+
+// normalizeContentType returns the lowercased media type of the Content-Type,
+// stripping any parameters (e.g. "; charset=utf-8")
+func normalizeContentType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		// fall back to stripping the parameters by hand for malformed headers
+		if i := strings.Index(contentType, ";"); i != -1 {
+			contentType = contentType[:i]
+		}
+		return strings.ToLower(strings.TrimSpace(contentType))
+	}
+	return mediaType
+}
+
 func jsonDecodeFunc(r io.Reader, v interface{}) error {
 	return json.NewDecoder(r).Decode(v)
 }
 
+// configuredJSONDecoder decodes with the json.Decoder settings of the Response
+func (resp *Response) configuredJSONDecoder(r io.Reader, v interface{}) error {
+	dec := json.NewDecoder(r)
+	if resp.jsonDisallowUnknownFields {
+		dec.DisallowUnknownFields()
+	}
+	if resp.jsonUseNumber {
+		dec.UseNumber()
+	}
+	return dec.Decode(v)
+}
+
 func gobDecodeFunc(r io.Reader, v interface{}) error {
 	return gob.NewDecoder(r).Decode(v)
 }
@@ -23,6 +84,66 @@ func xmlDecodeFunc(r io.Reader, v interface{}) error {
 	return xml.NewDecoder(r).Decode(v)
 }
 
+// setFieldFromString parses value into the field based on its kind
+// Empty values leave the field at its zero value, so a pointer field is only allocated for a non-empty value
+func setFieldFromString(field reflect.Value, value string) error {
+	if field.Kind() == reflect.Ptr {
+		if value == "" {
+			return nil
+		}
+		if field.IsNil() {
+			field.Set(reflect.New(field.Type().Elem()))
+		}
+		return setFieldFromString(field.Elem(), value)
+	}
+
+	if tu, ok := field.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return tu.UnmarshalText([]byte(value))
+	}
+
+	if value == "" {
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(i)
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetUint(u)
+
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+
+	default:
+		return errors.New("unsupported field type " + field.Type().String())
+	}
+
+	return nil
+}
+
 // DecodeOption is a func to configure optional Response settings
 type DecodeOption func(c context.Context, resp *Response) error
 
@@ -34,6 +155,27 @@ func WithJSONBody() DecodeOption {
 	}
 }
 
+// WithStrictJSONBody json decodes the body of the Response, failing if it contains fields that aren't in v
+// This catches upstream schema drift early, while WithJSONBody ignores unknown fields
+func WithStrictJSONBody() DecodeOption {
+	return func(c context.Context, resp *Response) error {
+		resp.jsonDisallowUnknownFields = true
+		resp.decodeFunc = resp.configuredJSONDecoder
+		return nil
+	}
+}
+
+// WithUseJSONNumber json decodes the body of the Response, decoding numbers into interface{} values as json.Number
+// instead of float64, so large int64 IDs keep their precision
+// It can be combined with WithStrictJSONBody
+func WithUseJSONNumber() DecodeOption {
+	return func(c context.Context, resp *Response) error {
+		resp.jsonUseNumber = true
+		resp.decodeFunc = resp.configuredJSONDecoder
+		return nil
+	}
+}
+
 // WithGobBody gob decodes the body of the Response
 func WithGobBody() DecodeOption {
 	return func(c context.Context, resp *Response) error {
@@ -50,19 +192,57 @@ func WithXMLBody() DecodeOption {
 	}
 }
 
+// WithCSVBody csv decodes the body of the Response into a *[][]string,
+// or a pointer to a slice of structs mapped from the header row by their `csv` tags
+// The field delimiter defaults to ',' and can be overridden with comma
+func WithCSVBody(comma ...rune) DecodeOption {
+	return func(c context.Context, resp *Response) error {
+		resp.decodeFunc = csvDecodeFunc
+		if len(comma) > 0 {
+			resp.decodeFunc = newCSVDecodeFunc(comma[0])
+		}
+		return nil
+	}
+}
+
+// WithFormBody decodes the application/x-www-form-urlencoded body of the Response into a *url.Values,
+// or a pointer to a struct mapped by its `form` or `url` tags
+func WithFormBody() DecodeOption {
+	return func(c context.Context, resp *Response) error {
+		resp.decodeFunc = formDecodeFunc
+		return nil
+	}
+}
+
 // WithCopiedBody makes a copy of the body available in the response.
 // This is helpful if you anticipate the decode failing and want to do a full
 // dump of the response.
 func WithCopiedBody() DecodeOption {
 	return func(c context.Context, resp *Response) error {
-		buf := getBuffer()
-		resp.body = io.TeeReader(resp.response.Body, buf)
+		buf := resp.reusableBuffer
+		if buf != nil {
+			buf.Reset()
+		} else {
+			buf = getBuffer()
+		}
+		resp.body = io.TeeReader(resp.body, buf)
 		resp.copiedBody = buf
 		resp.keepBody = true
 		return nil
 	}
 }
 
+// WithReusableBuffer uses buf instead of a pooled buffer to hold the body for Bytes and WithCopiedBody,
+// so hot paths can reuse one buffer across Responses without going through the pool
+// buf is reset before use and is never returned to the pool, and must be given before WithCopiedBody
+// NOTE: the bytes returned by Bytes are only valid until buf is reused
+func WithReusableBuffer(buf *bytes.Buffer) DecodeOption {
+	return func(c context.Context, resp *Response) error {
+		resp.reusableBuffer = buf
+		return nil
+	}
+}
+
 // WithCustomFunc uses the provided DecodeFunc to Decode the response
 func WithCustomFunc(decodeFunc DecodeFunc) DecodeOption {
 	return func(c context.Context, resp *Response) error {
@@ -0,0 +1,117 @@
+package fetcher
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// EncodeFunc writes the encoded v to w, for use as a Request payload
+type EncodeFunc func(w io.Writer, v interface{}) error
+
+var (
+	encodersMu sync.RWMutex
+	encoders   = map[string]EncodeFunc{
+		ContentTypeJSON:       jsonEncodeFunc,
+		ContentTypeGob:        gobEncodeFunc,
+		ContentTypeXML:        xmlEncodeFunc,
+		ContentTypeURLEncoded: urlEncodedEncodeFunc,
+	}
+)
+
+// RegisterEncoder makes the EncodeFunc available to WithPayload for the given Content-Type
+// JSON, Gob, XML and URL encoded form values are registered by default
+func RegisterEncoder(contentType string, encodeFunc EncodeFunc) {
+	encodersMu.Lock()
+	defer encodersMu.Unlock()
+	encoders[normalizeContentType(contentType)] = encodeFunc
+}
+
+// getEncoder returns the registered EncodeFunc for the given Content-Type
+func getEncoder(contentType string) (EncodeFunc, bool) {
+	encodersMu.RLock()
+	defer encodersMu.RUnlock()
+	encodeFunc, ok := encoders[normalizeContentType(contentType)]
+	return encodeFunc, ok
+}
+
+func jsonEncodeFunc(w io.Writer, v interface{}) error {
+	return json.NewEncoder(w).Encode(v)
+}
+
+func gobEncodeFunc(w io.Writer, v interface{}) error {
+	return gob.NewEncoder(w).Encode(v)
+}
+
+func xmlEncodeFunc(w io.Writer, v interface{}) error {
+	return xml.NewEncoder(w).Encode(v)
+}
+
+func urlEncodedEncodeFunc(w io.Writer, v interface{}) error {
+	values, ok := v.(url.Values)
+	if !ok {
+		return fmt.Errorf("%s payload must be url.Values, got %T", ContentTypeURLEncoded, v)
+	}
+	_, err := io.WriteString(w, values.Encode())
+	return err
+}
+
+// WithPayload encodes the payload for the Request with the EncodeFunc registered for contentType
+// and sets the content-type and accept header to contentType
+func WithPayload(contentType string, payload interface{}) RequestOption {
+	return func(c context.Context, req *Request) error {
+		if payload == nil {
+			return nil
+		}
+		req.headers = append(req.headers, newHeader(AcceptHeader, contentType))
+		req.headers = append(req.headers, newHeader(ContentTypeHeader, contentType))
+		return req.encodePayload(contentType, payload)
+	}
+}
+
+// WithBody sets the payload for the Request based on its type:
+// string and []byte payloads are sent as-is, io.Reader payloads are streamed,
+// and any other payload is encoded with the EncodeFunc registered for contentType (see WithPayload)
+// The content-type header is set to contentType unless it is empty
+func WithBody(payload interface{}, contentType string) RequestOption {
+	return func(c context.Context, req *Request) error {
+		switch v := payload.(type) {
+		case nil:
+			return nil
+		case string:
+			req.payload = strings.NewReader(v)
+		case []byte:
+			req.payload = bytes.NewReader(v)
+		case io.Reader:
+			req.payload = v
+		default:
+			return WithPayload(contentType, payload)(c, req)
+		}
+		if contentType != "" {
+			req.headers = append(req.headers, newHeader(ContentTypeHeader, contentType))
+		}
+		return nil
+	}
+}
+
+// encodePayload encodes the payload into a pooled buffer with the EncodeFunc registered for contentType
+func (req *Request) encodePayload(contentType string, payload interface{}) error {
+	encodeFunc, ok := getEncoder(contentType)
+	if !ok {
+		return fmt.Errorf("no encoder registered for %s", contentType)
+	}
+	buf := getBuffer()
+	if err := encodeFunc(buf, payload); err != nil {
+		putBuffer(buf)
+		return err
+	}
+	req.setPayloadBuffer(buf)
+	return nil
+}
@@ -0,0 +1,77 @@
+package fetcher
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrAttemptTimeout is returned when an attempt exceeds the WithAttemptTimeout duration
+var ErrAttemptTimeout = errors.New("fetcher: attempt timed out")
+
+// ErrResponseTooLarge is returned when reading a response body larger than the WithClientMaxResponseBytes limit
+var ErrResponseTooLarge = errors.New("fetcher: response body too large")
+
+// RequestError is returned by Client.Do when the Request couldn't be completed,
+// wrapping the underlying error so it can be inspected with errors.Is and errors.As
+// e.g. errors.Is(err, context.DeadlineExceeded) or errors.Is(err, context.Canceled)
+type RequestError struct {
+	// Err is the error from the last attempt
+	Err error
+
+	// Attempts is the number of attempts made, including fallback URLs
+	Attempts int
+
+	// LastStatus is the status code of the last response received, or 0 if there wasn't one
+	LastStatus int
+}
+
+func (e *RequestError) Error() string {
+	if e.LastStatus == 0 {
+		return fmt.Sprintf("fetcher: request failed after %d attempts: %s", e.Attempts, e.Err)
+	}
+	return fmt.Sprintf("fetcher: request failed after %d attempts (last status %d): %s", e.Attempts, e.LastStatus, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
+// newRequestError wraps err with the attempts made by the Request
+func (req *Request) newRequestError(err error) *RequestError {
+	return &RequestError{
+		Err:        err,
+		Attempts:   req.attempts,
+		LastStatus: req.lastStatus,
+	}
+}
+
+// StatusError is returned by Do when the response status code isn't one expected by WithExpectStatus,
+// or is one of the WithFailOnStatus codes, in which case Expected is empty
+type StatusError struct {
+	StatusCode int
+	Expected   []int
+	Body       []byte
+}
+
+func (e *StatusError) Error() string {
+	if len(e.Expected) == 0 {
+		return fmt.Sprintf("fetcher: status code %d treated as a failure | body: %s", e.StatusCode, e.Body)
+	}
+	return fmt.Sprintf("fetcher: unexpected status code %d, expected %v | body: %s", e.StatusCode, e.Expected, e.Body)
+}
+
+// ErrNoChecksum is returned by WithVerifyChecksum when the response has no supported checksum header
+var ErrNoChecksum = errors.New("fetcher: response has no supported checksum header")
+
+// ChecksumError is returned by WithVerifyChecksum when the body doesn't match a checksum header
+type ChecksumError struct {
+	// Algorithm is the digest algorithm, e.g. md5 or sha-256
+	Algorithm string
+	Expected  string
+	Actual    string
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("fetcher: %s checksum mismatch, expected %s, got %s", e.Algorithm, e.Expected, e.Actual)
+}
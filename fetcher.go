@@ -12,4 +12,5 @@ type Fetcher interface {
 	Put(c context.Context, url string, opts ...RequestOption) (*Response, error)
 	Patch(c context.Context, url string, opts ...RequestOption) (*Response, error)
 	Delete(c context.Context, url string, opts ...RequestOption) (*Response, error)
+	Options(c context.Context, url string, opts ...RequestOption) (*Response, error)
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/nozzle/fetcher"
 )
@@ -16,6 +17,12 @@ type Client struct {
 	fetcherClientOptions []fetcher.ClientOption
 	expectedRequests     []*ExpectedRequest
 
+	// every request given to Do, in order
+	receivedRequests []*fetcher.Request
+
+	// number of requests that matched an expectation, keyed by callKey
+	callCounts map[string]int
+
 	withExpectationsInOrder bool
 	expectationsMet         bool
 }
@@ -24,6 +31,7 @@ type Client struct {
 func NewClient(c context.Context, opts ...ClientOption) (*Client, error) {
 	cl := &Client{
 		expectedRequests:        []*ExpectedRequest{},
+		callCounts:              map[string]int{},
 		withExpectationsInOrder: true,
 		expectationsMet:         false,
 	}
@@ -52,6 +60,12 @@ func (cl *Client) Do(c context.Context, req *fetcher.Request) (*fetcher.Response
 		return nil, c.Err()
 	}
 
+	// buffer the payload if needed, so the received request stays inspectable after it has been matched
+	if _, err := req.PayloadBytes(); err != nil {
+		return nil, err
+	}
+	cl.receivedRequests = append(cl.receivedRequests, req)
+
 	// find the expected request in cl.expectedRequests
 	var expReqWasMet bool
 	var metIdx int
@@ -63,7 +77,7 @@ func (cl *Client) Do(c context.Context, req *fetcher.Request) (*fetcher.Response
 		}
 
 		// compare the expectations to the actual request
-		equal, info = cl.expectedRequests[i].request.Equal(req)
+		equal, info = matches(cl.expectedRequests[i], req)
 		if equal {
 			cl.expectedRequests[i].wasMet = true
 			expReqWasMet = true
@@ -82,14 +96,54 @@ func (cl *Client) Do(c context.Context, req *fetcher.Request) (*fetcher.Response
 		return nil, fmt.Errorf("Request did not match any ExpectedRequests | %s", req.String())
 	}
 
+	cl.callCounts[callKey(req.Method(), req.URL())]++
+
 	// if met, return the expReq.response
 	if cl.metCount() == len(cl.expectedRequests) {
 		cl.expectationsMet = true
 	}
 
+	if err := waitForResponse(c, cl.expectedRequests[metIdx].responseDelay); err != nil {
+		return nil, err
+	}
+
+	if err := cl.expectedRequests[metIdx].err; err != nil {
+		return nil, err
+	}
 	return cl.expectedRequests[metIdx].response, nil
 }
 
+// waitForResponse waits for the response delay, returning early with the error if the context is done
+func waitForResponse(c context.Context, delay time.Duration) error {
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-c.Done():
+		return c.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// ReceivedRequests returns every request given to Do, in order, whether or not it matched an expectation
+// Their payloads can still be read with PayloadBytes, e.g. to diff against what was expected
+func (cl *Client) ReceivedRequests() []*fetcher.Request {
+	return append([]*fetcher.Request(nil), cl.receivedRequests...)
+}
+
+// CallCount returns the number of requests with the given method and url that matched an expectation
+func (cl *Client) CallCount(method, url string) int {
+	return cl.callCounts[callKey(method, url)]
+}
+
+// callKey returns the callCounts key for the method and url
+func callKey(method, url string) string {
+	return method + " " + url
+}
+
 // UnmetExpectations returns the slice of ExpectedRequests that were not met in execution
 func (cl *Client) UnmetExpectations() []*ExpectedRequest {
 	unmet := make([]*ExpectedRequest, 0, len(cl.expectedRequests)-cl.metCount())
@@ -185,6 +239,25 @@ func (cl *Client) Delete(c context.Context, url string, opts ...fetcher.RequestO
 	return cl.Do(c, req)
 }
 
+// Options is a helper func for Do, setting the Method internally
+func (cl *Client) Options(c context.Context, url string, opts ...fetcher.RequestOption) (*fetcher.Response, error) {
+	req, err := cl.NewRequest(c, http.MethodOptions, url, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return cl.Do(c, req)
+}
+
+// Method is a helper func for Do, using the given method
+// This allows for non-standard methods, such as the WebDAV PROPFIND
+func (cl *Client) Method(c context.Context, method, url string, opts ...fetcher.RequestOption) (*fetcher.Response, error) {
+	req, err := cl.NewRequest(c, method, url, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return cl.Do(c, req)
+}
+
 // NewRequest returns a new Request with the given method/url and options executed
 func (cl *Client) NewRequest(c context.Context, method, url string, opts ...fetcher.RequestOption) (*fetcher.Request, error) {
 	return cl.fetcherClient.NewRequest(c, method, url, opts...)
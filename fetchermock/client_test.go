@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/nozzle/fetcher"
 	"github.com/nozzle/fetcher/fetchermock"
@@ -139,3 +141,166 @@ func sharedCount(c context.Context, f fetcher.Fetcher, uri string) (int, error)
 
 	return countResp.Count, nil
 }
+
+func TestURLMatcher(t *testing.T) {
+	c := context.Background()
+	fm, err := fetchermock.NewClient(c, fetchermock.WithExpectationsInOrder(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = fm.ExpectRequest(c, http.MethodGet, "",
+		fetchermock.WithURLRegex(`^https://api\.example\.com/items\?ts=\d+$`),
+		fetchermock.WithResponseStatusCode(http.StatusOK),
+	); err != nil {
+		t.Fatal(err)
+	}
+	if err = fm.ExpectRequest(c, http.MethodPost, "",
+		fetchermock.WithURLMatcher(func(url string) bool { return strings.HasPrefix(url, "https://api.example.com/jobs/") }),
+		fetchermock.WithRequestOptions(fetcher.WithBytesPayload([]byte("run"))),
+		fetchermock.WithResponseStatusCode(http.StatusAccepted),
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = fm.Get(c, "https://api.example.com/items?ts=abc"); err == nil {
+		t.Errorf("Get with a non-matching url succeeded")
+	}
+	if _, err = fm.Post(c, "https://api.example.com/jobs/42", fetcher.WithBytesPayload([]byte("other"))); err == nil {
+		t.Errorf("Post with a matching url but different payload succeeded")
+	}
+
+	if err = fm.ExpectRequest(c, http.MethodGet, "",
+		fetchermock.WithURLRegex(`^https://api\.example\.com/search\?`),
+		fetchermock.WithRequestOptions(fetcher.WithParam("q", "shoes")),
+		fetchermock.WithResponseStatusCode(http.StatusOK),
+	); err != nil {
+		t.Fatal(err)
+	}
+	// the expectation's params are only matched through the url, so they aren't added to the actual url again
+	if _, err = fm.Get(c, "https://api.example.com/search", fetcher.WithParam("q", "shoes")); err != nil {
+		t.Errorf("Get with a matching url and params failed: %v", err)
+	}
+
+	resp, err := fm.Get(c, "https://api.example.com/items?ts=1700000000")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if resp.StatusCode() != http.StatusOK {
+		t.Errorf("Get status = %d, want %d", resp.StatusCode(), http.StatusOK)
+	}
+	resp, err = fm.Post(c, "https://api.example.com/jobs/42", fetcher.WithBytesPayload([]byte("run")))
+	if err != nil {
+		t.Fatalf("Post failed: %v", err)
+	}
+	if resp.StatusCode() != http.StatusAccepted {
+		t.Errorf("Post status = %d, want %d", resp.StatusCode(), http.StatusAccepted)
+	}
+}
+
+func TestResponseDelayAndFailingBody(t *testing.T) {
+	c := context.Background()
+	errMidStream := errors.New("connection lost")
+	fm, err := fetchermock.NewClient(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = fm.ExpectRequest(c, http.MethodGet, "https://api.example.com/slow",
+		fetchermock.WithResponseDelay(time.Second),
+		fetchermock.WithResponseStatusCode(http.StatusOK),
+	); err != nil {
+		t.Fatal(err)
+	}
+	if err = fm.ExpectRequest(c, http.MethodGet, "https://api.example.com/stream",
+		fetchermock.WithResponseStatusCode(http.StatusOK),
+		fetchermock.WithResponseBodyReader(fetchermock.NewFailingReader(strings.NewReader(`{"count": 29, "url": "https://nozzle.io"}`), 10, errMidStream)),
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	timeoutC, cancel := context.WithTimeout(c, 10*time.Millisecond)
+	defer cancel()
+	if _, err = fm.Get(timeoutC, "https://api.example.com/slow"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Get err = %v, want context.DeadlineExceeded", err)
+	}
+
+	resp, err := fm.Get(c, "https://api.example.com/stream")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	var v map[string]interface{}
+	if err = resp.Decode(c, &v, fetcher.WithJSONBody()); !errors.Is(err, errMidStream) {
+		t.Errorf("Decode err = %v, want %v", err, errMidStream)
+	}
+}
+
+func TestCallCount(t *testing.T) {
+	c := context.Background()
+	const (
+		tokenURL = "https://auth.example.com/token"
+		dataURL  = "https://api.example.com/data"
+	)
+	fm, err := fetchermock.NewClient(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fm.ExpectRequest(c, http.MethodPost, tokenURL, fetchermock.WithResponseStatusCode(http.StatusOK))
+	fm.ExpectRequest(c, http.MethodGet, dataURL, fetchermock.WithResponseStatusCode(http.StatusServiceUnavailable))
+	fm.ExpectRequest(c, http.MethodGet, dataURL, fetchermock.WithResponseStatusCode(http.StatusOK))
+
+	// fetch the token once, then retry the data endpoint until it succeeds
+	if _, err = fm.Post(c, tokenURL); err != nil {
+		t.Fatalf("Post failed: %v", err)
+	}
+	for {
+		resp, err := fm.Get(c, dataURL)
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if resp.IsSuccess() {
+			break
+		}
+	}
+
+	if n := fm.CallCount(http.MethodPost, tokenURL); n != 1 {
+		t.Errorf("token endpoint called %d times, want 1", n)
+	}
+	if n := fm.CallCount(http.MethodGet, dataURL); n != 2 {
+		t.Errorf("data endpoint called %d times, want 2", n)
+	}
+	if n := fm.CallCount(http.MethodGet, tokenURL); n != 0 {
+		t.Errorf("GET token endpoint called %d times, want 0", n)
+	}
+}
+
+func TestReceivedRequests(t *testing.T) {
+	c := context.Background()
+	fm, err := fetchermock.NewClient(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fm.ExpectRequest(c, http.MethodPost, "https://api.example.com/items",
+		fetchermock.WithRequestOptions(fetcher.WithBytesPayload([]byte(`{"name":"a"}`))),
+		fetchermock.WithResponseStatusCode(http.StatusCreated),
+	)
+
+	if _, err = fm.Post(c, "https://api.example.com/items", fetcher.WithReaderPayload(strings.NewReader(`{"name":"b"}`))); err == nil {
+		t.Fatalf("Post with a different payload succeeded")
+	}
+	if _, err = fm.Post(c, "https://api.example.com/items", fetcher.WithBytesPayload([]byte(`{"name":"a"}`))); err != nil {
+		t.Fatalf("Post failed: %v", err)
+	}
+
+	received := fm.ReceivedRequests()
+	if len(received) != 2 {
+		t.Fatalf("received %d requests, want 2", len(received))
+	}
+	for i, want := range []string{`{"name":"b"}`, `{"name":"a"}`} {
+		payload, err := received[i].PayloadBytes()
+		if err != nil {
+			t.Fatalf("PayloadBytes failed: %v", err)
+		}
+		if string(payload) != want || received[i].Method() != http.MethodPost {
+			t.Errorf("received[%d] = %s %s, want POST %s", i, received[i].Method(), payload, want)
+		}
+	}
+}
@@ -3,9 +3,12 @@ package fetchermock
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"regexp"
+	"time"
 
 	"github.com/nozzle/fetcher"
 )
@@ -19,16 +22,20 @@ type ExpectedRequest struct {
 
 	wasMet bool
 
+	// matches the url instead of the url given to ExpectRequest
+	urlMatcher func(url string) bool
+
 	// response
 	responseBodyReader io.Reader
 	responseStatusCode int
 	responseStatus     string
-	responseHeaders    map[string]string
+	responseHeaders    http.Header
+	responseDelay      time.Duration
 }
 
 // ExpectRequest creates an ExpectedRequest and adds it to the cl.expectedRequests
 func (cl *Client) ExpectRequest(c context.Context, method, url string, opts ...ExpectedRequestOption) error {
-	expReq := &ExpectedRequest{responseHeaders: map[string]string{}}
+	expReq := &ExpectedRequest{responseHeaders: http.Header{}}
 
 	// execute all options
 	var err error
@@ -64,6 +71,42 @@ func WithRequestOptions(opts ...fetcher.RequestOption) ExpectedRequestOption {
 	}
 }
 
+// WithURLMatcher matches the ExpectedRequest to any request whose url satisfies matcher,
+// instead of requiring the url given to ExpectRequest, e.g. for urls containing timestamps or generated IDs
+// The rest of the request is still compared as usual
+func WithURLMatcher(matcher func(url string) bool) ExpectedRequestOption {
+	return func(c context.Context, expReq *ExpectedRequest) error {
+		expReq.urlMatcher = matcher
+		return nil
+	}
+}
+
+// WithURLRegex matches the ExpectedRequest to any request whose url matches the regular expression pattern (see WithURLMatcher)
+func WithURLRegex(pattern string) ExpectedRequestOption {
+	return func(c context.Context, expReq *ExpectedRequest) error {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return err
+		}
+		expReq.urlMatcher = re.MatchString
+		return nil
+	}
+}
+
+// matches compares the ExpectedRequest to the actual request
+// If not equal, a string is returned with first field found different
+func matches(expReq *ExpectedRequest, req *fetcher.Request) (bool, string) {
+	if expReq.urlMatcher == nil {
+		return expReq.request.Equal(req)
+	}
+	if !expReq.urlMatcher(req.URL()) {
+		return false, fmt.Sprintf("url: %s doesn't match the URL matcher", req.URL())
+	}
+
+	// the url, including any params, is only compared by the matcher
+	return expReq.request.EqualIgnoringURL(req)
+}
+
 // WithResponseStatusCode sets the responseStatusCode in the ExpectedRequest
 func WithResponseStatusCode(code int) ExpectedRequestOption {
 	return func(c context.Context, expReq *ExpectedRequest) error {
@@ -96,10 +139,46 @@ func WithResponseBodyReader(r io.Reader) ExpectedRequestOption {
 	}
 }
 
+// WithResponseDelay makes Do wait for d before responding, returning the context error if it's done first
+func WithResponseDelay(d time.Duration) ExpectedRequestOption {
+	return func(c context.Context, expReq *ExpectedRequest) error {
+		expReq.responseDelay = d
+		return nil
+	}
+}
+
+// NewFailingReader returns a reader of r that returns err once n bytes have been read,
+// for use with WithResponseBodyReader to simulate a body that fails mid-stream
+func NewFailingReader(r io.Reader, n int64, err error) io.Reader {
+	return &failingReader{r: io.LimitReader(r, n), err: err}
+}
+
+type failingReader struct {
+	r   io.Reader
+	err error
+}
+
+func (fr *failingReader) Read(p []byte) (int, error) {
+	n, err := fr.r.Read(p)
+	if err == io.EOF {
+		err = fr.err
+	}
+	return n, err
+}
+
 // WithResponseHeader sets the key/value in the responseHeader in the ExpectedRequest
 func WithResponseHeader(key, value string) ExpectedRequestOption {
 	return func(c context.Context, expReq *ExpectedRequest) error {
-		expReq.responseHeaders[key] = value
+		expReq.responseHeaders.Set(key, value)
+		return nil
+	}
+}
+
+// WithResponseHeaderValues sets every value of the key in the responseHeader in the ExpectedRequest,
+// for headers that are repeated, e.g. Set-Cookie or Link
+func WithResponseHeaderValues(key string, values ...string) ExpectedRequestOption {
+	return func(c context.Context, expReq *ExpectedRequest) error {
+		expReq.responseHeaders[http.CanonicalHeaderKey(key)] = append([]string(nil), values...)
 		return nil
 	}
 }
@@ -113,11 +192,8 @@ func WithResponseError(err error) ExpectedRequestOption {
 }
 
 func mockHTTPResponse(c context.Context, expReq *ExpectedRequest) *http.Response {
-	resp := &http.Response{Header: http.Header(map[string][]string{})}
+	resp := &http.Response{Header: expReq.responseHeaders.Clone()}
 	resp.Body = ioutil.NopCloser(expReq.responseBodyReader)
-	for key, value := range expReq.responseHeaders {
-		resp.Header.Set(key, value)
-	}
 	resp.StatusCode = expReq.responseStatusCode
 	resp.Status = expReq.responseStatus
 	return resp
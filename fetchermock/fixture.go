@@ -0,0 +1,113 @@
+package fetchermock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+
+	"github.com/nozzle/fetcher"
+)
+
+// Fixture describes the requests a mock Client expects, in order, and their canned responses
+// It's stored as JSON, e.g.
+//
+//	{
+//	  "interactions": [
+//	    {
+//	      "request": {"method": "POST", "url": "https://api.example.com/items", "maxAttempts": 3, "body": "{\"name\":\"a\"}"},
+//	      "response": {"statusCode": 201, "headers": {"Content-Type": ["application/json"]}, "body": "{\"id\":1}"}
+//	    },
+//	    {
+//	      "request": {"method": "GET", "url": "https://api.example.com/down"},
+//	      "response": {"error": "connection refused"}
+//	    }
+//	  ]
+//	}
+type Fixture struct {
+	Interactions []FixtureInteraction `json:"interactions"`
+}
+
+// FixtureInteraction is a single expected request and its response
+type FixtureInteraction struct {
+	Request  FixtureRequest  `json:"request"`
+	Response FixtureResponse `json:"response"`
+}
+
+// FixtureRequest describes the request to match
+// MaxAttempts defaults to 1, the fetcher default
+type FixtureRequest struct {
+	Method      string `json:"method"`
+	URL         string `json:"url"`
+	MaxAttempts int    `json:"maxAttempts,omitempty"`
+	Body        string `json:"body,omitempty"`
+}
+
+// FixtureResponse describes the canned response, or the error returned instead if Error is set
+// Headers holds every value of each header, so repeated headers such as Set-Cookie are kept
+type FixtureResponse struct {
+	StatusCode int                 `json:"statusCode,omitempty"`
+	Status     string              `json:"status,omitempty"`
+	Headers    map[string][]string `json:"headers,omitempty"`
+	Body       string              `json:"body,omitempty"`
+	Error      string              `json:"error,omitempty"`
+}
+
+// NewClientFromFixture returns a new Client expecting the requests in the JSON Fixture file at path
+func NewClientFromFixture(c context.Context, path string, opts ...ClientOption) (*Client, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	fixture := &Fixture{}
+	if err = json.Unmarshal(b, fixture); err != nil {
+		return nil, err
+	}
+
+	cl, err := NewClient(c, opts...)
+	if err != nil {
+		return nil, err
+	}
+	for _, interaction := range fixture.Interactions {
+		if err = cl.ExpectRequest(c, interaction.Request.Method, interaction.Request.URL, interaction.expectedRequestOptions()...); err != nil {
+			return nil, err
+		}
+	}
+	return cl, nil
+}
+
+// WriteFile writes the Fixture to path as indented JSON
+func (f *Fixture) WriteFile(path string) error {
+	b, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0644)
+}
+
+// expectedRequestOptions returns the ExpectedRequestOptions matching the FixtureInteraction
+func (interaction FixtureInteraction) expectedRequestOptions() []ExpectedRequestOption {
+	maxAttempts := interaction.Request.MaxAttempts
+	if maxAttempts == 0 {
+		maxAttempts = 1
+	}
+	reqOpts := []fetcher.RequestOption{fetcher.WithMaxAttempts(maxAttempts)}
+	if interaction.Request.Body != "" {
+		reqOpts = append(reqOpts, fetcher.WithBytesPayload([]byte(interaction.Request.Body)))
+	}
+	opts := []ExpectedRequestOption{WithRequestOptions(reqOpts...)}
+
+	if interaction.Response.Error != "" {
+		return append(opts, WithResponseError(errors.New(interaction.Response.Error)))
+	}
+
+	opts = append(opts,
+		WithResponseStatusCode(interaction.Response.StatusCode),
+		WithResponseStatus(interaction.Response.Status),
+		WithResponseBodyBytes([]byte(interaction.Response.Body)),
+	)
+	for key, values := range interaction.Response.Headers {
+		opts = append(opts, WithResponseHeaderValues(key, values...))
+	}
+	return opts
+}
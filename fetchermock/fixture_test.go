@@ -0,0 +1,90 @@
+package fetchermock_test
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nozzle/fetcher"
+	"github.com/nozzle/fetcher/fetchermock"
+)
+
+func TestFixtureRoundTrip(t *testing.T) {
+	c := context.Background()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set(fetcher.ContentTypeHeader, fetcher.ContentTypeJSON)
+		io.WriteString(w, `{"echo":`+string(body)+`}`)
+	}))
+
+	realClient, err := fetcher.NewClient(c)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	type result struct {
+		statusCode  int
+		contentType string
+		body        string
+	}
+	run := func(f fetcher.Fetcher) []result {
+		var results []result
+		for _, send := range []func() (*fetcher.Response, error){
+			func() (*fetcher.Response, error) {
+				return f.Post(c, ts.URL+"/items", fetcher.WithBytesPayload([]byte(`"hello"`)))
+			},
+			func() (*fetcher.Response, error) { return f.Get(c, ts.URL+"/missing") },
+		} {
+			resp, err := send()
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			body, err := resp.Bytes()
+			if err != nil {
+				t.Fatalf("Bytes failed: %v", err)
+			}
+			results = append(results, result{resp.StatusCode(), resp.ContentType(), string(body)})
+			resp.Close()
+		}
+		return results
+	}
+
+	recorder := fetchermock.Record(realClient)
+	recorded := run(recorder)
+
+	dir, err := ioutil.TempDir("", "fetchermock")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "fixture.json")
+	if err = recorder.Fixture().WriteFile(path); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	// replay offline from the fixture file
+	ts.Close()
+	fm, err := fetchermock.NewClientFromFixture(c, path)
+	if err != nil {
+		t.Fatalf("NewClientFromFixture failed: %v", err)
+	}
+	replayed := run(fm)
+
+	for i := range recorded {
+		if replayed[i] != recorded[i] {
+			t.Errorf("interaction %d replayed as %+v, recorded %+v", i, replayed[i], recorded[i])
+		}
+	}
+	if unmet := fm.UnmetExpectations(); len(unmet) != 0 {
+		t.Errorf("%d unmet expectations after replay", len(unmet))
+	}
+}
@@ -0,0 +1,245 @@
+package fetchermock
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/nozzle/fetcher"
+)
+
+var _ fetcher.Fetcher = (*Recorder)(nil)
+
+// Recorder wraps a real fetcher.Fetcher, recording every request and its response as they're executed
+// The recordings can then be replayed offline with Replay, saved as a Fixture, or emitted as ExpectRequest setup code with Code
+type Recorder struct {
+	fetcher fetcher.Fetcher
+
+	mu         sync.Mutex
+	recordings []*Recording
+}
+
+// Recording is a request and the response it received, as captured by a Recorder
+// The request headers aren't recorded, since the mock Client doesn't match requests on them
+type Recording struct {
+	Method      string
+	URL         string
+	MaxAttempts int
+	RequestBody []byte
+
+	StatusCode      int
+	Status          string
+	ResponseHeaders http.Header
+	ResponseBody    []byte
+
+	// Err is set instead of the response fields if the request failed
+	Err error
+}
+
+// Record returns a Recorder that executes requests with the given realClient
+func Record(realClient fetcher.Fetcher) *Recorder {
+	return &Recorder{fetcher: realClient}
+}
+
+// Do executes the request with the real client and records it along with its response
+// The response body is buffered to record it, and remains readable by the caller
+func (r *Recorder) Do(c context.Context, req *fetcher.Request) (*fetcher.Response, error) {
+	payload, err := req.PayloadBytes()
+	if err != nil {
+		return nil, err
+	}
+	rec := &Recording{
+		Method:      req.Method(),
+		URL:         req.URL(),
+		MaxAttempts: req.MaxAttempts(),
+		RequestBody: append([]byte(nil), payload...),
+	}
+
+	resp, err := r.fetcher.Do(c, req)
+	if err != nil {
+		rec.Err = err
+		r.add(rec)
+		return nil, err
+	}
+
+	body, err := resp.Bytes()
+	if err != nil {
+		resp.Close()
+		rec.Err = err
+		r.add(rec)
+		return nil, err
+	}
+	rec.StatusCode = resp.StatusCode()
+	rec.Status = resp.Status()
+	rec.ResponseHeaders = resp.Header().Clone()
+	rec.ResponseBody = append([]byte(nil), body...)
+	r.add(rec)
+
+	return resp, nil
+}
+
+// add appends the Recording
+func (r *Recorder) add(rec *Recording) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.recordings = append(r.recordings, rec)
+}
+
+// Recordings returns the requests recorded so far, in the order they were executed
+func (r *Recorder) Recordings() []*Recording {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*Recording(nil), r.recordings...)
+}
+
+// Replay returns a mock Client expecting every recorded request in order, responding as the real client did
+// The mock Client never touches the network
+func (r *Recorder) Replay(c context.Context, opts ...ClientOption) (*Client, error) {
+	cl, err := NewClient(c, opts...)
+	if err != nil {
+		return nil, err
+	}
+	for _, rec := range r.Recordings() {
+		opts := rec.interaction().expectedRequestOptions()
+		if rec.Err != nil {
+			// keep the original error, so it can still be inspected with errors.Is
+			opts = append(opts, WithResponseError(rec.Err))
+		}
+		if err = cl.ExpectRequest(c, rec.Method, rec.URL, opts...); err != nil {
+			return nil, err
+		}
+	}
+	return cl, nil
+}
+
+// Fixture returns the recordings as a Fixture, which can be saved with WriteFile and loaded by NewClientFromFixture
+func (r *Recorder) Fixture() *Fixture {
+	fixture := &Fixture{Interactions: []FixtureInteraction{}}
+	for _, rec := range r.Recordings() {
+		fixture.Interactions = append(fixture.Interactions, rec.interaction())
+	}
+	return fixture
+}
+
+// interaction returns the Recording as a FixtureInteraction
+func (rec *Recording) interaction() FixtureInteraction {
+	interaction := FixtureInteraction{
+		Request: FixtureRequest{
+			Method:      rec.Method,
+			URL:         rec.URL,
+			MaxAttempts: rec.MaxAttempts,
+			Body:        string(rec.RequestBody),
+		},
+	}
+	if rec.Err != nil {
+		interaction.Response.Error = rec.Err.Error()
+		return interaction
+	}
+
+	interaction.Response = FixtureResponse{
+		StatusCode: rec.StatusCode,
+		Status:     rec.Status,
+		Headers:    map[string][]string(rec.ResponseHeaders.Clone()),
+		Body:       string(rec.ResponseBody),
+	}
+	return interaction
+}
+
+// Code returns Go source calling ExpectRequest on fm for every recorded request,
+// for pasting into a test in place of hand-written expectations
+func (r *Recorder) Code() string {
+	var sb strings.Builder
+	for _, rec := range r.Recordings() {
+		fmt.Fprintf(&sb, "fm.ExpectRequest(c, %q, %q,\n", rec.Method, rec.URL)
+		fmt.Fprintf(&sb, "\tfetchermock.WithRequestOptions(\n\t\tfetcher.WithMaxAttempts(%d),\n", rec.MaxAttempts)
+		if len(rec.RequestBody) > 0 {
+			fmt.Fprintf(&sb, "\t\tfetcher.WithBytesPayload([]byte(%q)),\n", rec.RequestBody)
+		}
+		sb.WriteString("\t),\n")
+
+		if rec.Err != nil {
+			fmt.Fprintf(&sb, "\tfetchermock.WithResponseError(errors.New(%q)),\n)\n", rec.Err.Error())
+			continue
+		}
+
+		fmt.Fprintf(&sb, "\tfetchermock.WithResponseStatusCode(%d),\n", rec.StatusCode)
+		fmt.Fprintf(&sb, "\tfetchermock.WithResponseStatus(%q),\n", rec.Status)
+		for _, key := range sortedKeys(rec.ResponseHeaders) {
+			values := rec.ResponseHeaders[key]
+			if len(values) == 1 {
+				fmt.Fprintf(&sb, "\tfetchermock.WithResponseHeader(%q, %q),\n", key, values[0])
+				continue
+			}
+			fmt.Fprintf(&sb, "\tfetchermock.WithResponseHeaderValues(%q", key)
+			for _, value := range values {
+				fmt.Fprintf(&sb, ", %q", value)
+			}
+			sb.WriteString("),\n")
+		}
+		fmt.Fprintf(&sb, "\tfetchermock.WithResponseBodyBytes([]byte(%q)),\n)\n", rec.ResponseBody)
+	}
+	return sb.String()
+}
+
+// sortedKeys returns the header keys in sorted order, so the output is deterministic
+func sortedKeys(header http.Header) []string {
+	keys := make([]string, 0, len(header))
+	for key := range header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// NewRequest returns a new Request from the real client with the given method/url and options executed
+func (r *Recorder) NewRequest(c context.Context, method, url string, opts ...fetcher.RequestOption) (*fetcher.Request, error) {
+	return r.fetcher.NewRequest(c, method, url, opts...)
+}
+
+// Get is a helper func for Do, setting the Method internally
+func (r *Recorder) Get(c context.Context, url string, opts ...fetcher.RequestOption) (*fetcher.Response, error) {
+	return r.Method(c, http.MethodGet, url, opts...)
+}
+
+// Head is a helper func for Do, setting the Method internally
+func (r *Recorder) Head(c context.Context, url string, opts ...fetcher.RequestOption) (*fetcher.Response, error) {
+	return r.Method(c, http.MethodHead, url, opts...)
+}
+
+// Post is a helper func for Do, setting the Method internally
+func (r *Recorder) Post(c context.Context, url string, opts ...fetcher.RequestOption) (*fetcher.Response, error) {
+	return r.Method(c, http.MethodPost, url, opts...)
+}
+
+// Put is a helper func for Do, setting the Method internally
+func (r *Recorder) Put(c context.Context, url string, opts ...fetcher.RequestOption) (*fetcher.Response, error) {
+	return r.Method(c, http.MethodPut, url, opts...)
+}
+
+// Patch is a helper func for Do, setting the Method internally
+func (r *Recorder) Patch(c context.Context, url string, opts ...fetcher.RequestOption) (*fetcher.Response, error) {
+	return r.Method(c, http.MethodPatch, url, opts...)
+}
+
+// Delete is a helper func for Do, setting the Method internally
+func (r *Recorder) Delete(c context.Context, url string, opts ...fetcher.RequestOption) (*fetcher.Response, error) {
+	return r.Method(c, http.MethodDelete, url, opts...)
+}
+
+// Options is a helper func for Do, setting the Method internally
+func (r *Recorder) Options(c context.Context, url string, opts ...fetcher.RequestOption) (*fetcher.Response, error) {
+	return r.Method(c, http.MethodOptions, url, opts...)
+}
+
+// Method is a helper func for Do, using the given method
+// This allows for non-standard methods, such as the WebDAV PROPFIND
+func (r *Recorder) Method(c context.Context, method, url string, opts ...fetcher.RequestOption) (*fetcher.Response, error) {
+	req, err := r.NewRequest(c, method, url, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return r.Do(c, req)
+}
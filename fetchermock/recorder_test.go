@@ -0,0 +1,98 @@
+package fetchermock_test
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nozzle/fetcher"
+	"github.com/nozzle/fetcher/fetchermock"
+)
+
+func TestRecordReplay(t *testing.T) {
+	c := context.Background()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set(fetcher.ContentTypeHeader, fetcher.ContentTypeJSON)
+		w.Header().Add("Set-Cookie", "a=1")
+		w.Header().Add("Set-Cookie", "b=2")
+		io.WriteString(w, `{"echo":`+string(body)+`}`)
+	}))
+
+	realClient, err := fetcher.NewClient(c)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	recorder := fetchermock.Record(realClient)
+
+	post := func(f fetcher.Fetcher) string {
+		resp, err := f.Post(c, ts.URL+"/items", fetcher.WithMaxAttempts(2), fetcher.WithBytesPayload([]byte(`"hello"`)))
+		if err != nil {
+			t.Fatalf("Post failed: %v", err)
+		}
+		defer resp.Close()
+		if got, want := resp.Header()["Set-Cookie"], []string{"a=1", "b=2"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("Set-Cookie = %q, want %q", got, want)
+		}
+		body, err := resp.Bytes()
+		if err != nil {
+			t.Fatalf("Bytes failed: %v", err)
+		}
+		return string(body)
+	}
+
+	if got, want := post(recorder), `{"echo":"hello"}`; got != want {
+		t.Fatalf("recorded body = %s, want %s", got, want)
+	}
+
+	code := recorder.Code()
+	for _, want := range []string{
+		`fm.ExpectRequest(c, "POST", "` + ts.URL + `/items",`,
+		`fetcher.WithBytesPayload([]byte("\"hello\"")),`,
+		`fetchermock.WithResponseStatusCode(200),`,
+		`fetchermock.WithResponseHeader("Content-Type", "application/json"),`,
+		`fetchermock.WithResponseHeaderValues("Set-Cookie", "a=1", "b=2"),`,
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("Code() missing %s\n%s", want, code)
+		}
+	}
+
+	// replay offline
+	ts.Close()
+	fm, err := recorder.Replay(c)
+	if err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+	if got, want := post(fm), `{"echo":"hello"}`; got != want {
+		t.Errorf("replayed body = %s, want %s", got, want)
+	}
+	if unmet := fm.UnmetExpectations(); len(unmet) != 0 {
+		t.Errorf("%d unmet expectations after replay", len(unmet))
+	}
+
+	// the repeated header survives saving the fixture
+	dir, err := ioutil.TempDir("", "fetchermock")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "fixture.json")
+	if err = recorder.Fixture().WriteFile(path); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	fm, err = fetchermock.NewClientFromFixture(c, path)
+	if err != nil {
+		t.Fatalf("NewClientFromFixture failed: %v", err)
+	}
+	if got, want := post(fm), `{"echo":"hello"}`; got != want {
+		t.Errorf("fixture body = %s, want %s", got, want)
+	}
+}
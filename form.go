@@ -0,0 +1,179 @@
+package fetcher
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+func formDecodeFunc(r io.Reader, v interface{}) error {
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return err
+	}
+
+	if dst, ok := v.(*url.Values); ok {
+		*dst = values
+		return nil
+	}
+
+	return decodeFormValues(values, v)
+}
+
+// decodeFormValues sets the fields of the struct v points to from values,
+// matching keys to the `form` or `url` tags (or names) of the fields
+// Slice fields receive every value for their key, other fields receive the first
+func decodeFormValues(values url.Values, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("form: decode target must be a *url.Values or a pointer to a struct, got %T", v)
+	}
+
+	elem := rv.Elem()
+	elemType := elem.Type()
+	for i := 0; i < elemType.NumField(); i++ {
+		name, ok := formFieldName(elemType.Field(i))
+		if !ok {
+			continue
+		}
+		fieldValues, ok := values[name]
+		if !ok || len(fieldValues) == 0 {
+			continue
+		}
+
+		field := elem.Field(i)
+		if field.Kind() == reflect.Slice && field.Type().Elem().Kind() != reflect.Uint8 {
+			slice := reflect.MakeSlice(field.Type(), len(fieldValues), len(fieldValues))
+			for j := range fieldValues {
+				if err := setFieldFromString(slice.Index(j), fieldValues[j]); err != nil {
+					return fmt.Errorf("form: key %q: %s", name, err)
+				}
+			}
+			field.Set(slice)
+			continue
+		}
+
+		if err := setFieldFromString(field, fieldValues[0]); err != nil {
+			return fmt.Errorf("form: key %q: %s", name, err)
+		}
+	}
+
+	return nil
+}
+
+// encodeFormValues returns the fields of the struct v (or the struct it points to) as url.Values,
+// keyed the same way as decodeFormValues
+func encodeFormValues(v interface{}) (url.Values, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("form: payload must be a struct or a pointer to one, got %T", v)
+	}
+
+	values := url.Values{}
+	rvType := rv.Type()
+	for i := 0; i < rvType.NumField(); i++ {
+		name, ok := formFieldName(rvType.Field(i))
+		if !ok {
+			continue
+		}
+		field := rv.Field(i)
+		if formFieldOmitEmpty(rvType.Field(i)) && field.IsZero() {
+			continue
+		}
+
+		if field.Kind() == reflect.Slice && field.Type().Elem().Kind() != reflect.Uint8 {
+			for j := 0; j < field.Len(); j++ {
+				value, err := formatFieldString(field.Index(j))
+				if err != nil {
+					return nil, fmt.Errorf("form: key %q: %s", name, err)
+				}
+				values.Add(name, value)
+			}
+			continue
+		}
+
+		value, err := formatFieldString(field)
+		if err != nil {
+			return nil, fmt.Errorf("form: key %q: %s", name, err)
+		}
+		values.Set(name, value)
+	}
+
+	return values, nil
+}
+
+// formatFieldString formats the field based on its kind, the reverse of setFieldFromString
+// A nil pointer formats as an empty string
+func formatFieldString(field reflect.Value) (string, error) {
+	if field.Kind() == reflect.Ptr {
+		if field.IsNil() {
+			return "", nil
+		}
+		field = field.Elem()
+	}
+	if tm, ok := field.Interface().(encoding.TextMarshaler); ok {
+		b, err := tm.MarshalText()
+		return string(b), err
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		return field.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(field.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(field.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(field.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(field.Float(), 'f', -1, field.Type().Bits()), nil
+	}
+	return "", errors.New("unsupported field type " + field.Type().String())
+}
+
+// formFieldName returns the key for the struct field from its `form` or `url` tag, falling back to the field name
+// false is returned for unexported fields and fields tagged "-"
+func formFieldName(field reflect.StructField) (string, bool) {
+	if field.PkgPath != "" {
+		return "", false
+	}
+	tag := field.Tag.Get("form")
+	if tag == "" {
+		tag = field.Tag.Get("url")
+	}
+	name := strings.Split(tag, ",")[0]
+	switch name {
+	case "-":
+		return "", false
+	case "":
+		return field.Name, true
+	}
+	return name, true
+}
+
+// formFieldOmitEmpty reports whether the `form` or `url` tag of the struct field has the omitempty option
+func formFieldOmitEmpty(field reflect.StructField) bool {
+	tag := field.Tag.Get("form")
+	if tag == "" {
+		tag = field.Tag.Get("url")
+	}
+	for _, opt := range strings.Split(tag, ",")[1:] {
+		if opt == "omitempty" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,134 @@
+package fetcher
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+type formTestObject struct {
+	AccessToken string   `form:"access_token"`
+	ExpiresIn   int      `url:"expires_in"`
+	Scope       []string `form:"scope"`
+	TokenType   string
+	Limit       *int `form:"limit"`
+}
+
+func TestFormDecode(t *testing.T) {
+	body := "access_token=abc123&expires_in=3600&scope=read&scope=write&TokenType=bearer"
+	tests := []struct {
+		name          string
+		decodeOptions []DecodeOption
+		got           interface{}
+		want          interface{}
+	}{
+		{
+			"detected into url.Values",
+			[]DecodeOption{},
+			&url.Values{},
+			&url.Values{
+				"access_token": []string{"abc123"},
+				"expires_in":   []string{"3600"},
+				"scope":        []string{"read", "write"},
+				"TokenType":    []string{"bearer"},
+			},
+		},
+		{
+			"WithFormBody into struct",
+			[]DecodeOption{WithFormBody()},
+			&formTestObject{},
+			&formTestObject{AccessToken: "abc123", ExpiresIn: 3600, Scope: []string{"read", "write"}, TokenType: "bearer"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := context.Background()
+			ts := testServerHelper(t, &serverData{
+				headers:    map[string]string{ContentTypeHeader: ContentTypeURLEncoded},
+				body:       []byte(body),
+				statusCode: http.StatusOK,
+			})
+			defer ts.Close()
+
+			cl, err := NewClient(c)
+			if err != nil {
+				t.Fatalf("NewClient failed: %v", err)
+			}
+
+			resp, err := cl.Post(c, ts.URL, WithURLEncodedPayload(url.Values{"grant_type": []string{"client_credentials"}}))
+			if err != nil {
+				t.Fatalf("cl.Post failed: %v", err)
+			}
+
+			if err = resp.Decode(c, tt.got, tt.decodeOptions...); err != nil {
+				t.Fatalf("resp.Decode failed: %v", err)
+			}
+
+			if !reflect.DeepEqual(tt.got, tt.want) {
+				t.Errorf("got = %v, want %v", tt.got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormStruct(t *testing.T) {
+	c := context.Background()
+	var gotBody url.Values
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("r.ParseForm failed: %v", err)
+		}
+		gotBody = r.PostForm
+		w.Header().Set(ContentTypeHeader, r.Header.Get(ContentTypeHeader))
+		w.Write([]byte(r.PostForm.Encode()))
+	}))
+	defer ts.Close()
+
+	cl, err := NewClient(c)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	limit := 50
+	sent := struct {
+		AccessToken  string   `form:"access_token"`
+		ExpiresIn    int      `url:"expires_in"`
+		Scope        []string `form:"scope"`
+		TokenType    string
+		RefreshToken string `form:"refresh_token,omitempty"`
+		Internal     string `form:"-"`
+		Limit        *int   `form:"limit"`
+	}{AccessToken: "abc123", ExpiresIn: 3600, Scope: []string{"read", "write"}, TokenType: "bearer", Internal: "secret", Limit: &limit}
+
+	resp, err := cl.Post(c, ts.URL, WithFormStruct(&sent))
+	if err != nil {
+		t.Fatalf("cl.Post failed: %v", err)
+	}
+
+	wantBody := url.Values{
+		"access_token": []string{"abc123"},
+		"expires_in":   []string{"3600"},
+		"scope":        []string{"read", "write"},
+		"TokenType":    []string{"bearer"},
+		"limit":        []string{"50"},
+	}
+	if !reflect.DeepEqual(gotBody, wantBody) {
+		t.Errorf("server got form %v, want %v", gotBody, wantBody)
+	}
+
+	var got formTestObject
+	if err = resp.Decode(c, &got, WithFormBody()); err != nil {
+		t.Fatalf("resp.Decode failed: %v", err)
+	}
+	want := formTestObject{AccessToken: "abc123", ExpiresIn: 3600, Scope: []string{"read", "write"}, TokenType: "bearer", Limit: &limit}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("round-tripped struct = %+v, want %+v", got, want)
+	}
+
+	if _, err = cl.Post(c, ts.URL, WithFormStruct("not a struct")); err == nil {
+		t.Error("WithFormStruct of a string succeeded, want an error")
+	}
+}
@@ -1,5 +1,11 @@
 module github.com/nozzle/fetcher
 
-require go.opencensus.io v0.18.0
+require (
+	github.com/andybalholm/brotli v1.0.4
+	github.com/klauspost/compress v1.12.3
+	go.opencensus.io v0.18.0
+	golang.org/x/time v0.3.0
+	google.golang.org/protobuf v1.31.0
+)
 
 go 1.13
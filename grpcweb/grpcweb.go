@@ -0,0 +1,176 @@
+// Package grpcweb registers a gRPC-Web encoder and decoder with fetcher, for calling unary gRPC-Web methods without a gRPC stack
+// Import it to allow WithGRPCWebPayload and Decode to send and receive protobuf messages,
+// keeping the protobuf dependency out of fetcher itself
+package grpcweb
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/nozzle/fetcher"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// ContentType is the Content-Type of gRPC-Web requests and responses with protobuf messages
+	ContentType = "application/grpc-web+proto"
+
+	// StatusHeader is the trailer (or header, for a response without a message) holding the gRPC status code
+	StatusHeader = "Grpc-Status"
+
+	// MessageHeader is the trailer (or header) holding the percent-encoded gRPC status message
+	MessageHeader = "Grpc-Message"
+
+	// frame flags, see https://github.com/grpc/grpc/blob/master/doc/PROTOCOL-WEB.md
+	flagCompressed = 0x01
+	flagTrailer    = 0x80
+
+	// the flag byte and the 4 byte big-endian length of the frame
+	frameHeaderLen = 5
+)
+
+// Status is the error returned for a response with a non-zero grpc-status
+type Status struct {
+	Code    int
+	Message string
+}
+
+func (s *Status) Error() string {
+	return fmt.Sprintf("grpcweb: status %d: %s", s.Code, s.Message)
+}
+
+func init() {
+	fetcher.RegisterEncoder(ContentType, encodeFunc)
+	fetcher.RegisterDecoder(ContentType, decodeFunc)
+}
+
+// WithGRPCWebPayload sets the payload to msg framed as a gRPC-Web message, with the content-type and accept headers set to ContentType
+// The Request should be a POST to the /package.Service/Method path
+func WithGRPCWebPayload(msg proto.Message) fetcher.RequestOption {
+	return func(c context.Context, req *fetcher.Request) error {
+		if err := fetcher.WithHeader("X-Grpc-Web", "1")(c, req); err != nil {
+			return err
+		}
+		return fetcher.WithPayload(ContentType, msg)(c, req)
+	}
+}
+
+// Decode unframes the message of the gRPC-Web resp into msg, returning a *Status error if the call failed
+// The status is read from the trailer frame, or from the headers of a response without a message
+func Decode(c context.Context, resp *fetcher.Response, msg proto.Message) error {
+	if err := headerStatus(resp.Header()); err != nil {
+		resp.Close()
+		return err
+	}
+	return resp.Decode(c, msg, fetcher.WithCustomFunc(decodeFunc))
+}
+
+func encodeFunc(w io.Writer, v interface{}) error {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("%s payload must be a proto.Message, got %T", ContentType, v)
+	}
+	b, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return writeFrame(w, 0, b)
+}
+
+// writeFrame writes b to w with the gRPC-Web frame header
+func writeFrame(w io.Writer, flag byte, b []byte) error {
+	var header [frameHeaderLen]byte
+	header[0] = flag
+	binary.BigEndian.PutUint32(header[1:], uint32(len(b)))
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+// decodeFunc unmarshals the single message frame of the body into v, and checks the status of the trailer frame
+func decodeFunc(r io.Reader, v interface{}) error {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("%s decode target must be a proto.Message, got %T", ContentType, v)
+	}
+
+	var gotMessage, gotTrailer bool
+	for {
+		var header [frameHeaderLen]byte
+		if _, err := io.ReadFull(r, header[:]); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("grpcweb: reading frame header: %w", err)
+		}
+		b := make([]byte, binary.BigEndian.Uint32(header[1:]))
+		if _, err := io.ReadFull(r, b); err != nil {
+			return fmt.Errorf("grpcweb: reading frame: %w", err)
+		}
+
+		switch flag := header[0]; {
+		case flag&flagTrailer != 0:
+			gotTrailer = true
+			trailer, err := parseTrailer(b)
+			if err != nil {
+				return err
+			}
+			if err = headerStatus(trailer); err != nil {
+				return err
+			}
+		case flag&flagCompressed != 0:
+			return errors.New("grpcweb: compressed messages aren't supported")
+		case gotMessage:
+			return errors.New("grpcweb: more than one message in a unary response")
+		default:
+			gotMessage = true
+			if err := proto.Unmarshal(b, msg); err != nil {
+				return err
+			}
+		}
+	}
+
+	if !gotMessage && !gotTrailer {
+		return errors.New("grpcweb: empty response")
+	}
+	return nil
+}
+
+// parseTrailer parses the "key: value\r\n" lines of a trailer frame
+func parseTrailer(b []byte) (http.Header, error) {
+	// the trailer has no blank line ending it, so add one for ReadMIMEHeader
+	tr := textproto.NewReader(bufio.NewReader(io.MultiReader(bytes.NewReader(b), strings.NewReader("\r\n"))))
+	mimeHeader, err := tr.ReadMIMEHeader()
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("grpcweb: invalid trailer: %w", err)
+	}
+	return http.Header(mimeHeader), nil
+}
+
+// headerStatus returns a *Status error if h has a non-zero grpc-status
+func headerStatus(h http.Header) error {
+	status := strings.TrimSpace(h.Get(StatusHeader))
+	if status == "" || status == "0" {
+		return nil
+	}
+	code, err := strconv.Atoi(status)
+	if err != nil {
+		return fmt.Errorf("grpcweb: invalid %s %q", StatusHeader, status)
+	}
+	message := h.Get(MessageHeader)
+	if unescaped, err := url.PathUnescape(message); err == nil {
+		message = unescaped
+	}
+	return &Status{Code: code, Message: message}
+}
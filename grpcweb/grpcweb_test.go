@@ -0,0 +1,97 @@
+package grpcweb_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nozzle/fetcher"
+	"github.com/nozzle/fetcher/grpcweb"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func frame(flag byte, b []byte) []byte {
+	header := make([]byte, 5)
+	header[0] = flag
+	binary.BigEndian.PutUint32(header[1:], uint32(len(b)))
+	return append(header, b...)
+}
+
+func TestGRPCWeb(t *testing.T) {
+	c := context.Background()
+	hello, err := proto.Marshal(wrapperspb.String("hello"))
+	if err != nil {
+		t.Fatalf("proto.Marshal failed: %v", err)
+	}
+
+	// the server echoes the message back, failing calls to the missing method
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get(fetcher.ContentTypeHeader); got != grpcweb.ContentType {
+			t.Errorf("Content-Type = %s, want %s", got, grpcweb.ContentType)
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("reading request body failed: %v", err)
+		}
+		w.Header().Set(fetcher.ContentTypeHeader, grpcweb.ContentType)
+		switch r.URL.Path {
+		case "/echo.Echo/Echo":
+			if !bytes.Equal(body, frame(0, hello)) {
+				t.Errorf("request body = %q, want the framed message", body)
+			}
+			w.Write(frame(0, hello))
+			w.Write(frame(0x80, []byte("grpc-status: 0\r\ngrpc-message: \r\n")))
+		case "/echo.Echo/Unavailable":
+			// a trailers-only response has the status in its headers
+			w.Header().Set(grpcweb.StatusHeader, "14")
+			w.Header().Set(grpcweb.MessageHeader, "try%20again")
+		default:
+			w.Write(frame(0x80, []byte("grpc-status: 12\r\ngrpc-message: unknown%20method\r\n")))
+		}
+	}))
+	defer ts.Close()
+
+	cl, err := fetcher.NewClient(c, fetcher.WithRequestOptions([]fetcher.RequestOption{fetcher.WithBaseURL(ts.URL)}))
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	tests := []struct {
+		path    string
+		want    string
+		wantErr *grpcweb.Status
+	}{
+		{"/echo.Echo/Echo", "hello", nil},
+		{"/echo.Echo/Missing", "", &grpcweb.Status{Code: 12, Message: "unknown method"}},
+		{"/echo.Echo/Unavailable", "", &grpcweb.Status{Code: 14, Message: "try again"}},
+	}
+	for _, tt := range tests {
+		resp, err := cl.Post(c, tt.path, grpcweb.WithGRPCWebPayload(wrapperspb.String("hello")))
+		if err != nil {
+			t.Fatalf("%s: cl.Post failed: %v", tt.path, err)
+		}
+
+		var got wrapperspb.StringValue
+		err = grpcweb.Decode(c, resp, &got)
+		resp.Close()
+		if tt.wantErr != nil {
+			var status *grpcweb.Status
+			if !errors.As(err, &status) || *status != *tt.wantErr {
+				t.Errorf("%s: Decode error = %v, want %v", tt.path, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: Decode failed: %v", tt.path, err)
+		}
+		if got.GetValue() != tt.want {
+			t.Errorf("%s: message = %q, want %q", tt.path, got.GetValue(), tt.want)
+		}
+	}
+}
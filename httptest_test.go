@@ -1,14 +1,39 @@
 package fetcher
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/gob"
 	"encoding/json"
+	"encoding/pem"
 	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/http/httptrace"
+	"net/url"
 	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
 )
 
 type serverData struct {
@@ -112,6 +137,62 @@ func TestEndToEndWithObject(t *testing.T) {
 			[]DecodeOption{},
 			testObject{URL: "https://nozzle.io/", Count: 30},
 		},
+		{
+			"JSON detect encoding with charset parameter",
+			context.Background(),
+			[]ClientOption{},
+			http.MethodGet,
+			[]RequestOption{},
+			&serverData{
+				headers:    map[string]string{ContentTypeHeader: "application/json; charset=utf-8"},
+				body:       []byte(`{"URL":"https://nozzle.io/","Count":30}`),
+				statusCode: 200,
+			},
+			[]DecodeOption{},
+			testObject{URL: "https://nozzle.io/", Count: 30},
+		},
+		{
+			"JSON detect encoding with uppercase media type",
+			context.Background(),
+			[]ClientOption{},
+			http.MethodGet,
+			[]RequestOption{},
+			&serverData{
+				headers:    map[string]string{ContentTypeHeader: "Application/JSON"},
+				body:       []byte(`{"URL":"https://nozzle.io/","Count":30}`),
+				statusCode: 200,
+			},
+			[]DecodeOption{},
+			testObject{URL: "https://nozzle.io/", Count: 30},
+		},
+		{
+			"XML detect encoding with uppercase media type and charset parameter",
+			context.Background(),
+			[]ClientOption{},
+			http.MethodGet,
+			[]RequestOption{},
+			&serverData{
+				headers:    map[string]string{ContentTypeHeader: "APPLICATION/XML; Charset=UTF-8"},
+				body:       []byte(`<testObject><URL>https://nozzle.io/</URL><Count>30</Count></testObject>`),
+				statusCode: 200,
+			},
+			[]DecodeOption{},
+			testObject{URL: "https://nozzle.io/", Count: 30},
+		},
+		{
+			"registered decoder detect encoding",
+			context.Background(),
+			[]ClientOption{},
+			http.MethodGet,
+			[]RequestOption{},
+			&serverData{
+				headers:    map[string]string{ContentTypeHeader: "application/vnd.nozzle+json; version=2"},
+				body:       []byte(`{"URL":"https://nozzle.io/","Count":30}`),
+				statusCode: 200,
+			},
+			[]DecodeOption{},
+			testObject{URL: "https://nozzle.io/", Count: 30},
+		},
 		{
 			"Basic JSON with custom decode func",
 			context.Background(),
@@ -183,6 +264,8 @@ func TestEndToEndWithObject(t *testing.T) {
 			testObject{URL: "https://nozzle.io/", Count: 30},
 		},
 	}
+	RegisterDecoder("application/vnd.nozzle+json", jsonDecodeFunc)
+
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			ts := testServerHelper(t, tt.serverData)
@@ -331,7 +414,7 @@ func TestEndToEndGetPostPutPatchWithObject(t *testing.T) {
 	}
 }
 
-func TestEndToEndHeadDelete(t *testing.T) {
+func TestEndToEndHeadDeleteOptions(t *testing.T) {
 	tests := []struct {
 		name           string
 		c              context.Context
@@ -360,6 +443,26 @@ func TestEndToEndHeadDelete(t *testing.T) {
 				statusCode: 200,
 			},
 		},
+		{
+			"cl.Options",
+			context.Background(),
+			[]ClientOption{},
+			http.MethodOptions,
+			[]RequestOption{},
+			&serverData{
+				statusCode: 204,
+			},
+		},
+		{
+			"cl.Method",
+			context.Background(),
+			[]ClientOption{},
+			"PROPFIND",
+			[]RequestOption{},
+			&serverData{
+				statusCode: 207,
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -378,6 +481,10 @@ func TestEndToEndHeadDelete(t *testing.T) {
 				resp, err = cl.Head(tt.c, ts.URL, tt.requestOptions...)
 			case http.MethodDelete:
 				resp, err = cl.Delete(tt.c, ts.URL, tt.requestOptions...)
+			case http.MethodOptions:
+				resp, err = cl.Options(tt.c, ts.URL, tt.requestOptions...)
+			default:
+				resp, err = cl.Method(tt.c, tt.method, ts.URL, tt.requestOptions...)
 			}
 			if err != nil {
 				t.Errorf("cl.Do failed: %v", err)
@@ -391,3 +498,2738 @@ func TestEndToEndHeadDelete(t *testing.T) {
 		})
 	}
 }
+
+func TestResponsePeek(t *testing.T) {
+	c := context.Background()
+	ts := testServerHelper(t, &serverData{
+		headers:    map[string]string{ContentTypeHeader: ContentTypeJSON},
+		body:       []byte(`{"URL":"https://nozzle.io/","Count":30}`),
+		statusCode: 200,
+	})
+	defer ts.Close()
+
+	cl, err := NewClient(c)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	resp, err := cl.Get(c, ts.URL)
+	if err != nil {
+		t.Fatalf("cl.Get failed: %v", err)
+	}
+	defer resp.Close()
+
+	peeked, err := resp.Peek(7)
+	if err != nil {
+		t.Fatalf("resp.Peek failed: %v", err)
+	}
+	if string(peeked) != `{"URL":` {
+		t.Errorf("peeked = %s, want %s", peeked, `{"URL":`)
+	}
+
+	// peeking past the end of the body returns the full body
+	peeked, err = resp.Peek(1024)
+	if err != nil {
+		t.Fatalf("resp.Peek failed: %v", err)
+	}
+	if string(peeked) != `{"URL":"https://nozzle.io/","Count":30}` {
+		t.Errorf("peeked = %s, want full body", peeked)
+	}
+
+	got := testObject{}
+	if err = resp.Decode(c, &got); err != nil {
+		t.Fatalf("resp.Decode failed: %v", err)
+	}
+	want := testObject{URL: "https://nozzle.io/", Count: 30}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got = %v, want %v", got, want)
+	}
+}
+
+func TestWireDump(t *testing.T) {
+	c := context.Background()
+	ts := testServerHelper(t, &serverData{
+		headers:    map[string]string{ContentTypeHeader: ContentTypeJSON},
+		body:       []byte(`{"URL":"https://nozzle.io/","Count":30}`),
+		statusCode: 200,
+	})
+	defer ts.Close()
+
+	cl, err := NewClient(c)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	var reqDump, respDump []byte
+	resp, err := cl.Post(c, ts.URL,
+		WithBytesPayload([]byte("ping")),
+		WithBasicAuth("user", "secret"),
+		WithWireDump(func(dump []byte, isRequest bool) {
+			if isRequest {
+				reqDump = dump
+				return
+			}
+			respDump = dump
+		}),
+	)
+	if err != nil {
+		t.Fatalf("cl.Post failed: %v", err)
+	}
+	defer resp.Close()
+
+	if !bytes.HasPrefix(reqDump, []byte("POST / HTTP/1.1")) || !bytes.HasSuffix(reqDump, []byte("ping")) {
+		t.Errorf("request dump = %q", reqDump)
+	}
+	if !bytes.Contains(reqDump, []byte("Authorization: [REDACTED]")) {
+		t.Errorf("request dump = %q, want redacted Authorization header", reqDump)
+	}
+	if !bytes.HasPrefix(respDump, []byte("HTTP/1.1 200 OK")) || !bytes.Contains(respDump, []byte(`"Count":30`)) {
+		t.Errorf("response dump = %q", respDump)
+	}
+
+	// the response body must still be readable after being dumped
+	bts, err := resp.Bytes()
+	if err != nil {
+		t.Fatalf("resp.Bytes failed: %v", err)
+	}
+	if string(bts) != `{"URL":"https://nozzle.io/","Count":30}` {
+		t.Errorf("body = %s", bts)
+	}
+}
+
+func TestMaxRedirects(t *testing.T) {
+	c := context.Background()
+	var hits int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		http.Redirect(w, r, "/loop", http.StatusFound)
+	}))
+	defer ts.Close()
+
+	cl, err := NewClient(c, WithMaxRedirects(3))
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	if _, err = cl.Get(c, ts.URL); err == nil {
+		t.Fatal("cl.Get error = nil, want redirect error")
+	}
+
+	// the original request plus 2 followed redirects
+	if hits != 3 {
+		t.Errorf("hits = %d, want 3", hits)
+	}
+}
+
+func TestDecompression(t *testing.T) {
+	c := context.Background()
+	want := `{"URL":"https://nozzle.io/","Count":30}`
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		gw := gzip.NewWriter(&buf)
+		gw.Write([]byte(want))
+		gw.Close()
+		w.Header().Set(ContentTypeHeader, ContentTypeJSON)
+		w.Header().Set(ContentEncodingHeader, "gzip")
+		w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+		w.Write(buf.Bytes())
+	}))
+	defer ts.Close()
+
+	cl, err := NewClient(c)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	resp, err := cl.Get(c, ts.URL, WithDecompression("gzip"))
+	if err != nil {
+		t.Fatalf("cl.Get failed: %v", err)
+	}
+	defer resp.Close()
+
+	// the compressed Content-Length doesn't describe the decompressed body
+	if got := resp.Header().Get("Content-Length"); got != "" {
+		t.Errorf("Content-Length header = %q, want it removed", got)
+	}
+	if got := resp.ContentLength(); got != -1 {
+		t.Errorf("ContentLength() = %d, want -1", got)
+	}
+	if got := resp.UncompressedSize(); got != -1 {
+		t.Errorf("UncompressedSize() before reading = %d, want -1", got)
+	}
+
+	got := testObject{}
+	if err = resp.Decode(c, &got); err != nil {
+		t.Fatalf("resp.Decode failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, testObject{URL: "https://nozzle.io/", Count: 30}) {
+		t.Errorf("got = %v", got)
+	}
+	if got := resp.UncompressedSize(); got != int64(len(want)) {
+		t.Errorf("UncompressedSize() after reading = %d, want %d", got, len(want))
+	}
+}
+
+func TestLogRequestBody(t *testing.T) {
+	c := context.Background()
+	body := "the quick brown fox jumps over the lazy dog"
+
+	tests := []struct {
+		name           string
+		requestOptions []RequestOption
+		wantLog        string
+	}{
+		{
+			"bytes payload",
+			[]RequestOption{WithBytesPayload([]byte(body)), WithLogRequestBody(9)},
+			"fetcher: request payload (first 9 bytes): 'the quick'",
+		},
+		{
+			"streaming payload",
+			[]RequestOption{WithReaderPayload(io.MultiReader(strings.NewReader(body))), WithLogRequestBody(9)},
+			"fetcher: request payload (first 9 bytes): 'the quick'",
+		},
+		{
+			"sensitive payload",
+			[]RequestOption{WithBytesPayload([]byte(body)), WithLogRequestBody(9), WithSensitivePayload()},
+			"fetcher: request payload: [REDACTED]",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var received []byte
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				received, _ = ioutil.ReadAll(r.Body)
+			}))
+			defer ts.Close()
+
+			var logs []string
+			cl, err := NewClient(c, WithClientDebugLogFunc(func(s string) { logs = append(logs, s) }))
+			if err != nil {
+				t.Fatalf("NewClient failed: %v", err)
+			}
+
+			resp, err := cl.Post(c, ts.URL, tt.requestOptions...)
+			if err != nil {
+				t.Fatalf("cl.Post failed: %v", err)
+			}
+			resp.Close()
+
+			if string(received) != body {
+				t.Errorf("received = %s, want %s", received, body)
+			}
+
+			var found bool
+			for _, l := range logs {
+				if l == tt.wantLog {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("logs = %v, want %s", logs, tt.wantLog)
+			}
+		})
+	}
+}
+
+func TestRetryReplaysPayload(t *testing.T) {
+	c := context.Background()
+	var bodies []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		if len(bodies) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer ts.Close()
+
+	cl, err := NewClient(c)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	resp, err := cl.Post(c, ts.URL, WithJSONPayload(testObject{URL: "https://nozzle.io/", Count: 30}), WithMaxAttempts(2), WithNoBackoff(0))
+	if err != nil {
+		t.Fatalf("cl.Post failed: %v", err)
+	}
+	resp.Close()
+
+	want := []string{`{"URL":"https://nozzle.io/","Count":30}` + "\n", `{"URL":"https://nozzle.io/","Count":30}` + "\n"}
+	if !reflect.DeepEqual(bodies, want) {
+		t.Errorf("bodies = %q, want %q", bodies, want)
+	}
+}
+
+func TestRetrySkippedWhenDeadlineTooClose(t *testing.T) {
+	c := context.Background()
+	var hits int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	cl, err := NewClient(c)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	start := time.Now()
+	resp, err := cl.Get(c, ts.URL, WithMaxAttempts(3), WithNoBackoff(time.Second), WithTimeout(200*time.Millisecond))
+	if err != nil {
+		t.Fatalf("cl.Get failed: %v", err)
+	}
+	defer resp.Close()
+
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Errorf("elapsed = %s, want the backoff delay to be skipped", elapsed)
+	}
+	if hits != 1 {
+		t.Errorf("hits = %d, want 1", hits)
+	}
+	if resp.StatusCode() != http.StatusServiceUnavailable {
+		t.Errorf("StatusCode() = %d, want %d", resp.StatusCode(), http.StatusServiceUnavailable)
+	}
+}
+
+func TestDecodeCancelledDuringSlowBody(t *testing.T) {
+	c := context.Background()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(ContentTypeHeader, ContentTypeJSON)
+		w.Write([]byte(`{"URL":"`))
+		w.(http.Flusher).Flush()
+		// drip the rest of the body far slower than the decode deadline
+		for i := 0; i < 20; i++ {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(50 * time.Millisecond):
+			}
+			w.Write([]byte("a"))
+			w.(http.Flusher).Flush()
+		}
+		w.Write([]byte(`"}`))
+	}))
+	defer ts.Close()
+
+	cl, err := NewClient(c)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	resp, err := cl.Get(c, ts.URL)
+	if err != nil {
+		t.Fatalf("cl.Get failed: %v", err)
+	}
+	defer resp.Close()
+
+	decodeCtx, cancel := context.WithTimeout(c, 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err = resp.Decode(decodeCtx, &testObject{})
+	if err != context.DeadlineExceeded {
+		t.Errorf("resp.Decode error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed >= 500*time.Millisecond {
+		t.Errorf("elapsed = %s, want the decode aborted at the deadline", elapsed)
+	}
+}
+
+func TestFallbackURLs(t *testing.T) {
+	c := context.Background()
+	payload := `{"URL":"https://nozzle.io/","Count":30}` + "\n"
+
+	dead := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	dead.Close()
+
+	var unhealthyHits int
+	unhealthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		unhealthyHits++
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer unhealthy.Close()
+
+	var received string
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		received = string(body) + "?" + r.URL.RawQuery
+	}))
+	defer healthy.Close()
+
+	cl, err := NewClient(c)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	// each url keeps its own query, with the params added to it
+	resp, err := cl.Post(c, dead.URL+"?key=primary",
+		WithJSONPayload(testObject{URL: "https://nozzle.io/", Count: 30}),
+		WithParam("foo", "bar"),
+		WithMaxAttempts(2),
+		WithNoBackoff(0),
+		WithFallbackURLs(unhealthy.URL, healthy.URL+"?key=secondary"),
+	)
+	if err != nil {
+		t.Fatalf("cl.Post failed: %v", err)
+	}
+	defer resp.Close()
+
+	if resp.StatusCode() != http.StatusOK {
+		t.Errorf("StatusCode() = %d, want %d", resp.StatusCode(), http.StatusOK)
+	}
+	if unhealthyHits != 2 {
+		t.Errorf("unhealthyHits = %d, want 2", unhealthyHits)
+	}
+	if want := payload + "?foo=bar&key=secondary"; received != want {
+		t.Errorf("received = %q, want %q", received, want)
+	}
+}
+
+func TestResolveHost(t *testing.T) {
+	c := context.Background()
+	var gotHost string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHost = r.Host
+	}))
+	defer ts.Close()
+
+	tsURL, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatalf("url.Parse failed: %v", err)
+	}
+
+	cl, err := NewClient(c, WithResolveHost("nozzle.invalid", tsURL.Hostname()))
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	resp, err := cl.Get(c, "http://nozzle.invalid:"+tsURL.Port())
+	if err != nil {
+		t.Fatalf("cl.Get failed: %v", err)
+	}
+	resp.Close()
+
+	if gotHost != "nozzle.invalid:"+tsURL.Port() {
+		t.Errorf("Host = %s, want nozzle.invalid:%s", gotHost, tsURL.Port())
+	}
+}
+
+func TestResponseThresholds(t *testing.T) {
+	largeBody := strings.Repeat("a", 2048)
+	tests := []struct {
+		name           string
+		handler        http.HandlerFunc
+		requestOptions []RequestOption
+		wantLogPrefix  string
+	}{
+		{
+			"slow response",
+			func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(50 * time.Millisecond)
+			},
+			[]RequestOption{WithSlowResponseThreshold(10 * time.Millisecond)},
+			"fetcher: slow response: took",
+		},
+		{
+			"large response with Content-Length",
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(largeBody))
+			},
+			[]RequestOption{WithLargeResponseThreshold(1024)},
+			"fetcher: large response: 2048 bytes, threshold 1024 bytes",
+		},
+		{
+			"large chunked response",
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(largeBody[:1024]))
+				w.(http.Flusher).Flush()
+				w.Write([]byte(largeBody[1024:]))
+			},
+			[]RequestOption{WithLargeResponseThreshold(1024)},
+			"fetcher: large response: 2048 bytes, threshold 1024 bytes",
+		},
+		{
+			"within thresholds",
+			func(w http.ResponseWriter, r *http.Request) {},
+			[]RequestOption{WithSlowResponseThreshold(time.Second), WithLargeResponseThreshold(1024)},
+			"",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := context.Background()
+			ts := httptest.NewServer(tt.handler)
+			defer ts.Close()
+
+			var logs []string
+			cl, err := NewClient(c, WithClientErrorLogFunc(func(s string) { logs = append(logs, s) }))
+			if err != nil {
+				t.Fatalf("NewClient failed: %v", err)
+			}
+
+			resp, err := cl.Get(c, ts.URL, tt.requestOptions...)
+			if err != nil {
+				t.Fatalf("cl.Get failed: %v", err)
+			}
+			if _, err = resp.Bytes(); err != nil {
+				t.Fatalf("resp.Bytes failed: %v", err)
+			}
+
+			switch {
+			case tt.wantLogPrefix == "" && len(logs) != 0:
+				t.Errorf("logs = %v, want none", logs)
+			case tt.wantLogPrefix != "" && (len(logs) != 1 || !strings.HasPrefix(logs[0], tt.wantLogPrefix)):
+				t.Errorf("logs = %v, want one log starting with %q", logs, tt.wantLogPrefix)
+			}
+		})
+	}
+}
+
+func TestOnRetry(t *testing.T) {
+	c := context.Background()
+	var hits int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		if hits < 3 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer ts.Close()
+
+	cl, err := NewClient(c)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	var attempts []int
+	resp, err := cl.Get(c, ts.URL,
+		WithMaxAttempts(3),
+		WithNoBackoff(time.Millisecond),
+		WithOnRetry(func(attempt int, resp *Response, err error, nextDelay time.Duration) {
+			if err != nil || resp.StatusCode() != http.StatusInternalServerError || nextDelay != time.Millisecond {
+				t.Errorf("onRetry(%d, %v, %v, %s)", attempt, resp, err, nextDelay)
+			}
+			attempts = append(attempts, attempt)
+		}),
+	)
+	if err != nil {
+		t.Fatalf("cl.Get failed: %v", err)
+	}
+	resp.Close()
+
+	if !reflect.DeepEqual(attempts, []int{1, 2}) {
+		t.Errorf("attempts = %v, want [1 2]", attempts)
+	}
+}
+
+func TestGetWithPayload(t *testing.T) {
+	c := context.Background()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			t.Errorf("method = %s, want GET", r.Method)
+			return
+		}
+		if ct := r.Header.Get(ContentTypeHeader); ct != ContentTypeJSON {
+			t.Errorf("Content-Type = %q, want %q", ct, ContentTypeJSON)
+			return
+		}
+		w.Header().Set(ContentTypeHeader, ContentTypeJSON)
+		io.Copy(w, r.Body)
+	}))
+	defer ts.Close()
+
+	cl, err := NewClient(c)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	query := map[string]interface{}{
+		"query": map[string]interface{}{
+			"match": map[string]interface{}{"title": "fetcher"},
+		},
+	}
+	resp, err := cl.Get(c, ts.URL, WithJSONPayload(query))
+	if err != nil {
+		t.Fatalf("cl.Get failed: %v", err)
+	}
+
+	var echoed map[string]interface{}
+	if err = resp.Decode(c, &echoed, WithJSONBody()); err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if !reflect.DeepEqual(echoed, query) {
+		t.Errorf("echoed body = %v, want %v", echoed, query)
+	}
+}
+
+func TestWarmup(t *testing.T) {
+	c := context.Background()
+	const conns = 3
+
+	var newConns int32
+	arrived := make(chan struct{}, conns)
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead {
+			return
+		}
+		// hold every warmup request until all have arrived, so each needs its own connection
+		arrived <- struct{}{}
+		for len(arrived) < conns {
+			time.Sleep(time.Millisecond)
+		}
+	}))
+	ts.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&newConns, 1)
+		}
+	}
+	ts.Start()
+	defer ts.Close()
+
+	cl, err := NewClient(c, WithMaxIdleConnsPerHost(conns))
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	if err = cl.Warmup(c, ts.URL, conns); err != nil {
+		t.Fatalf("Warmup failed: %v", err)
+	}
+	if n := atomic.LoadInt32(&newConns); n != conns {
+		t.Fatalf("warmup opened %d connections, want %d", n, conns)
+	}
+
+	for i := 0; i < conns; i++ {
+		resp, err := cl.Get(c, ts.URL)
+		if err != nil {
+			t.Fatalf("cl.Get failed: %v", err)
+		}
+		resp.Close()
+	}
+	if n := atomic.LoadInt32(&newConns); n != conns {
+		t.Errorf("requests after warmup opened %d new connections, want 0", n-conns)
+	}
+}
+
+func TestRetryOnConnectionReset(t *testing.T) {
+	c := context.Background()
+	tests := []struct {
+		name     string
+		opts     []RequestOption
+		wantErr  bool
+		wantHits int32
+	}{
+		{"retried by default", nil, false, 2},
+		{"disabled", []RequestOption{WithRetryOnConnectionReset(false)}, true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hits int32
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if atomic.AddInt32(&hits, 1) > 1 {
+					return
+				}
+				// reset the connection instead of responding
+				conn, _, err := w.(http.Hijacker).Hijack()
+				if err != nil {
+					t.Errorf("Hijack failed: %v", err)
+					return
+				}
+				conn.(*net.TCPConn).SetLinger(0)
+				conn.Close()
+			}))
+			defer ts.Close()
+
+			cl, err := NewClient(c)
+			if err != nil {
+				t.Fatalf("NewClient failed: %v", err)
+			}
+
+			opts := append([]RequestOption{WithMaxAttempts(2), WithNoBackoff(time.Millisecond)}, tt.opts...)
+			resp, err := cl.Get(c, ts.URL, opts...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("cl.Get err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if resp != nil {
+				resp.Close()
+			}
+			if n := atomic.LoadInt32(&hits); n != tt.wantHits {
+				t.Errorf("hits = %d, want %d", n, tt.wantHits)
+			}
+		})
+	}
+}
+
+func TestAttemptTimeout(t *testing.T) {
+	c := context.Background()
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) == 1 {
+			// hang past the attempt timeout
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+			return
+		}
+		io.WriteString(w, "ok")
+	}))
+	defer ts.Close()
+
+	cl, err := NewClient(c)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	resp, err := cl.Get(c, ts.URL, WithMaxAttempts(2), WithNoBackoff(time.Millisecond), WithAttemptTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatalf("cl.Get failed: %v", err)
+	}
+	defer resp.Close()
+
+	body, err := resp.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes failed: %v", err)
+	}
+	if string(body) != "ok" {
+		t.Errorf("body = %q, want %q", body, "ok")
+	}
+	if n := atomic.LoadInt32(&hits); n != 2 {
+		t.Errorf("hits = %d, want 2", n)
+	}
+
+	// a single attempt returns the timeout
+	atomic.StoreInt32(&hits, 0)
+	_, err = cl.Get(c, ts.URL, WithAttemptTimeout(50*time.Millisecond))
+	if !errors.Is(err, ErrAttemptTimeout) {
+		t.Errorf("cl.Get err = %v, want ErrAttemptTimeout", err)
+	}
+}
+
+func TestRequestError(t *testing.T) {
+	hang := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer hang.Close()
+
+	closed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	closed.Close()
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	tests := []struct {
+		name         string
+		c            context.Context
+		url          string
+		opts         []RequestOption
+		wantIs       error
+		wantAttempts int
+	}{
+		{"cancel", cancelled, hang.URL, nil, context.Canceled, 1},
+		{"deadline", context.Background(), hang.URL, []RequestOption{WithTimeout(50 * time.Millisecond)}, context.DeadlineExceeded, 1},
+		{"network failure", context.Background(), closed.URL, nil, syscall.ECONNREFUSED, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cl, err := NewClient(tt.c)
+			if err != nil {
+				t.Fatalf("NewClient failed: %v", err)
+			}
+
+			_, err = cl.Get(tt.c, tt.url, tt.opts...)
+			var reqErr *RequestError
+			if !errors.As(err, &reqErr) {
+				t.Fatalf("cl.Get err = %v, want *RequestError", err)
+			}
+			if !errors.Is(err, tt.wantIs) {
+				t.Errorf("errors.Is(%v, %v) = false", err, tt.wantIs)
+			}
+			if reqErr.Attempts != tt.wantAttempts || reqErr.LastStatus != 0 {
+				t.Errorf("Attempts, LastStatus = %d, %d, want %d, 0", reqErr.Attempts, reqErr.LastStatus, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestHostHeader(t *testing.T) {
+	c := context.Background()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Host)
+	}))
+	defer ts.Close()
+
+	cl, err := NewClient(c)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	resp, err := cl.Get(c, ts.URL, WithHostHeader("api.example.com"))
+	if err != nil {
+		t.Fatalf("cl.Get failed: %v", err)
+	}
+	defer resp.Close()
+
+	host, err := resp.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes failed: %v", err)
+	}
+	if string(host) != "api.example.com" {
+		t.Errorf("server saw Host %q, want %q", host, "api.example.com")
+	}
+}
+
+func TestThrottleDuration(t *testing.T) {
+	c := context.Background()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	cl, err := NewClient(c, WithRateLimit(1, 20*time.Millisecond, 1, false))
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	// the first request uses the burst, the second waits for the rate
+	for i, check := range []func(time.Duration) bool{
+		func(d time.Duration) bool { return d < 5*time.Millisecond },
+		func(d time.Duration) bool { return d > 10*time.Millisecond && d < 40*time.Millisecond },
+	} {
+		resp, err := cl.Get(c, ts.URL)
+		if err != nil {
+			t.Fatalf("cl.Get failed: %v", err)
+		}
+		resp.Close()
+		if d := resp.ThrottleDuration(); !check(d) {
+			t.Errorf("request %d: ThrottleDuration() = %s", i+1, d)
+		}
+	}
+}
+
+func TestResponsePipe(t *testing.T) {
+	c := context.Background()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(ContentTypeHeader, ContentTypeJSON)
+		w.Header().Set("X-Request-Id", "abc")
+		w.Header().Set("Connection", "X-Hop")
+		w.Header().Set("X-Hop", "1")
+		w.Header().Set("Keep-Alive", "timeout=5")
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, `{"URL": "https://example.com"}`)
+	}))
+	defer ts.Close()
+
+	cl, err := NewClient(c)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	for _, copyHeaders := range []bool{true, false} {
+		resp, err := cl.Get(c, ts.URL)
+		if err != nil {
+			t.Fatalf("cl.Get failed: %v", err)
+		}
+
+		rec := httptest.NewRecorder()
+		n, err := resp.Pipe(rec, copyHeaders)
+		if err != nil {
+			t.Fatalf("Pipe failed: %v", err)
+		}
+
+		if body := rec.Body.String(); n != int64(len(body)) || body != `{"URL": "https://example.com"}` {
+			t.Errorf("piped %d bytes %q", n, body)
+		}
+		if rec.Code != http.StatusCreated {
+			t.Errorf("piped status = %d, want %d", rec.Code, http.StatusCreated)
+		}
+		if got := rec.Header().Get("X-Request-Id") == "abc"; got != copyHeaders {
+			t.Errorf("copyHeaders %v: X-Request-Id copied = %v", copyHeaders, got)
+		}
+		for _, key := range []string{"Connection", "X-Hop", "Keep-Alive"} {
+			if value := rec.Header().Get(key); value != "" {
+				t.Errorf("hop-by-hop header %s = %q was copied", key, value)
+			}
+		}
+		if !resp.bodyClosed {
+			t.Errorf("body not closed after Pipe")
+		}
+	}
+}
+
+func TestExpectStatus(t *testing.T) {
+	c := context.Background()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/found" {
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, "no such item")
+		}
+	}))
+	defer ts.Close()
+
+	cl, err := NewClient(c)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	resp, err := cl.Get(c, ts.URL+"/found", WithExpectStatus(http.StatusOK, http.StatusNoContent))
+	if err != nil {
+		t.Fatalf("cl.Get failed: %v", err)
+	}
+	resp.Close()
+
+	_, err = cl.Get(c, ts.URL+"/missing", WithExpectStatus(http.StatusOK, http.StatusNoContent))
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) {
+		t.Fatalf("cl.Get err = %v, want *StatusError", err)
+	}
+	if statusErr.StatusCode != http.StatusNotFound || string(statusErr.Body) != "no such item" ||
+		!reflect.DeepEqual(statusErr.Expected, []int{http.StatusOK, http.StatusNoContent}) {
+		t.Errorf("StatusError = %+v", statusErr)
+	}
+	if want := "fetcher: unexpected status code 404, expected [200 204] | body: no such item"; err.Error() != want {
+		t.Errorf("err = %q, want %q", err.Error(), want)
+	}
+}
+
+func TestFailOnStatus(t *testing.T) {
+	c := context.Background()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/empty":
+			w.WriteHeader(http.StatusNoContent)
+		case "/envelope":
+			io.WriteString(w, `{"error":"quota exceeded"}`)
+		}
+	}))
+	defer ts.Close()
+
+	cl, err := NewClient(c)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	_, err = cl.Get(c, ts.URL+"/empty", WithFailOnStatus(http.StatusNoContent))
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) {
+		t.Fatalf("cl.Get err = %v, want *StatusError", err)
+	}
+	if statusErr.StatusCode != http.StatusNoContent || len(statusErr.Expected) != 0 {
+		t.Errorf("StatusError = %+v", statusErr)
+	}
+	if want := "fetcher: status code 204 treated as a failure | body: "; err.Error() != want {
+		t.Errorf("err = %q, want %q", err.Error(), want)
+	}
+
+	// the body is attached, and codes that aren't listed are returned as usual
+	_, err = cl.Get(c, ts.URL+"/envelope", WithFailOnStatus(http.StatusOK))
+	if !errors.As(err, &statusErr) || string(statusErr.Body) != `{"error":"quota exceeded"}` {
+		t.Errorf("cl.Get err = %v, want a *StatusError with the body", err)
+	}
+	resp, err := cl.Get(c, ts.URL+"/envelope", WithFailOnStatus(http.StatusNoContent, http.StatusPartialContent))
+	if err != nil {
+		t.Fatalf("cl.Get failed: %v", err)
+	}
+	resp.Close()
+}
+
+func TestClientDefaultAccept(t *testing.T) {
+	c := context.Background()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Header.Get(AcceptHeader))
+	}))
+	defer ts.Close()
+
+	cl, err := NewClient(c, WithClientDefaultAccept(ContentTypeJSON))
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		opts []RequestOption
+		want string
+	}{
+		{"default", nil, ContentTypeJSON},
+		{"overridden", []RequestOption{WithHeader(AcceptHeader, ContentTypeXML)}, ContentTypeXML},
+		{"payload", []RequestOption{WithGobPayload(testObject{})}, ContentTypeGob},
+	}
+	for _, tt := range tests {
+		resp, err := cl.Post(c, ts.URL, tt.opts...)
+		if err != nil {
+			t.Fatalf("%s: cl.Post failed: %v", tt.name, err)
+		}
+		accept, err := resp.Bytes()
+		if err != nil {
+			t.Fatalf("%s: Bytes failed: %v", tt.name, err)
+		}
+		if string(accept) != tt.want {
+			t.Errorf("%s: Accept = %q, want %q", tt.name, accept, tt.want)
+		}
+	}
+}
+
+func TestIfMatch(t *testing.T) {
+	c := context.Background()
+	etag := `"v1"`
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set(ETagHeader, etag)
+		case http.MethodPut:
+			if r.Header.Get(IfMatchHeader) != etag {
+				w.WriteHeader(http.StatusPreconditionFailed)
+				return
+			}
+			etag = `"v2"`
+			w.Header().Set(ETagHeader, etag)
+		}
+	}))
+	defer ts.Close()
+
+	cl, err := NewClient(c)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	resp, err := cl.Get(c, ts.URL)
+	if err != nil {
+		t.Fatalf("cl.Get failed: %v", err)
+	}
+	resp.Close()
+	fetchedETag := resp.ETag()
+
+	// the first update succeeds, a second one with the same stale ETag fails the precondition
+	for _, want := range []int{http.StatusOK, http.StatusPreconditionFailed} {
+		resp, err = cl.Put(c, ts.URL, WithIfMatch(fetchedETag), WithJSONPayload(testObject{Count: 1}))
+		if err != nil {
+			t.Fatalf("cl.Put failed: %v", err)
+		}
+		resp.Close()
+		if resp.StatusCode() != want {
+			t.Errorf("PUT If-Match %s status = %d, want %d", fetchedETag, resp.StatusCode(), want)
+		}
+	}
+	if resp.ETag() != "" {
+		t.Errorf("412 response ETag = %s, want none", resp.ETag())
+	}
+}
+
+func TestChunkedEncoding(t *testing.T) {
+	c := context.Background()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("reading body failed: %v", err)
+			return
+		}
+		fmt.Fprintf(w, "%v %d %s", r.TransferEncoding, r.ContentLength, body)
+	}))
+	defer ts.Close()
+
+	cl, err := NewClient(c)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		opts []RequestOption
+		want string
+	}{
+		{"content length", []RequestOption{WithBytesPayload([]byte("payload"))}, "[] 7 payload"},
+		{"chunked", []RequestOption{WithBytesPayload([]byte("payload")), WithChunkedEncoding()}, "[chunked] -1 payload"},
+	}
+	for _, tt := range tests {
+		resp, err := cl.Post(c, ts.URL, tt.opts...)
+		if err != nil {
+			t.Fatalf("%s: cl.Post failed: %v", tt.name, err)
+		}
+		got, err := resp.Bytes()
+		if err != nil {
+			t.Fatalf("%s: Bytes failed: %v", tt.name, err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: server saw %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMaxConcurrentRequests(t *testing.T) {
+	c := context.Background()
+	const maxConcurrent = 3
+
+	var inFlight, maxInFlight int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			max := atomic.LoadInt32(&maxInFlight)
+			if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}))
+	defer ts.Close()
+
+	cl, err := NewClient(c, WithMaxConcurrentRequests(maxConcurrent), WithMaxIdleConnsPerHost(20))
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := cl.Get(c, ts.URL)
+			if err != nil {
+				t.Errorf("cl.Get failed: %v", err)
+				return
+			}
+			resp.Close()
+		}()
+	}
+	wg.Wait()
+
+	if max := atomic.LoadInt32(&maxInFlight); max != maxConcurrent {
+		t.Errorf("max requests in flight = %d, want %d", max, maxConcurrent)
+	}
+
+	// a request waiting for a slot returns once its context is done
+	for i := 0; i < maxConcurrent; i++ {
+		cl.requestSem <- struct{}{}
+	}
+	timeoutC, cancel := context.WithTimeout(c, 10*time.Millisecond)
+	defer cancel()
+	if _, err = cl.Get(timeoutC, ts.URL); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("cl.Get err = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestStreamingJSONPayload(t *testing.T) {
+	c := context.Background()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ct := r.Header.Get(ContentTypeHeader); ct != ContentTypeJSON {
+			t.Errorf("Content-Type = %q, want %q", ct, ContentTypeJSON)
+		}
+		if r.ContentLength != -1 {
+			t.Errorf("ContentLength = %d, want -1 for a streamed payload", r.ContentLength)
+		}
+		var count, total int
+		dec := json.NewDecoder(r.Body)
+		for {
+			var obj testObject
+			if err := dec.Decode(&obj); err == io.EOF {
+				break
+			} else if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			count++
+			total += obj.Count
+		}
+		fmt.Fprintf(w, "%d %d", count, total)
+	}))
+	defer ts.Close()
+
+	cl, err := NewClient(c)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	resp, err := cl.Post(c, ts.URL, WithStreamingJSONPayload(func(enc *json.Encoder) error {
+		for i := 1; i <= 1000; i++ {
+			if err := enc.Encode(testObject{URL: "https://example.com", Count: i}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	if err != nil {
+		t.Fatalf("cl.Post failed: %v", err)
+	}
+	got, err := resp.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes failed: %v", err)
+	}
+	if string(got) != "1000 500500" {
+		t.Errorf("server decoded %s, want 1000 500500", got)
+	}
+
+	// an encoding error fails the request
+	errEncode := errors.New("generating values failed")
+	_, err = cl.Post(c, ts.URL, WithStreamingJSONPayload(func(enc *json.Encoder) error {
+		if err := enc.Encode(testObject{Count: 1}); err != nil {
+			return err
+		}
+		return errEncode
+	}))
+	if !errors.Is(err, errEncode) {
+		t.Errorf("cl.Post err = %v, want %v", err, errEncode)
+	}
+
+	// the encoder goroutine ends when the Request can't be created, or its context is done before it's sent
+	streamUntilFailed := func(done chan<- error) RequestOption {
+		return WithStreamingJSONPayload(func(enc *json.Encoder) error {
+			for {
+				if err := enc.Encode(testObject{Count: 1}); err != nil {
+					done <- err
+					return err
+				}
+			}
+		})
+	}
+	done := make(chan error, 1)
+	if _, err = cl.NewRequest(c, http.MethodPost, "://bad url", streamUntilFailed(done)); err == nil {
+		t.Error("NewRequest with an invalid url succeeded")
+	}
+	cc, cancel := context.WithCancel(c)
+	cancelled := make(chan error, 1)
+	if _, err = cl.NewRequest(cc, http.MethodPost, ts.URL, streamUntilFailed(cancelled)); err != nil {
+		t.Fatalf("NewRequest failed: %v", err)
+	}
+	cancel()
+	for name, ch := range map[string]chan error{"invalid url": done, "cancelled context": cancelled} {
+		select {
+		case <-ch:
+		case <-time.After(time.Second):
+			t.Errorf("%s: the encoder goroutine is still blocked on the pipe", name)
+		}
+	}
+
+	// compressing or checksumming would have to buffer the stream
+	for _, opt := range []RequestOption{WithGzipPayload(), WithContentMD5()} {
+		if _, err = cl.NewRequest(c, http.MethodPost, ts.URL, streamUntilFailed(make(chan error, 1)), opt); err == nil {
+			t.Error("NewRequest combining a streaming payload with compression or a checksum succeeded")
+		}
+	}
+
+	// a Request that Do rejects before sending doesn't leave its encoder goroutines behind
+	goroutines := runtime.NumGoroutine()
+	for i := 0; i < 10; i++ {
+		if _, err = cl.Post(c, ts.URL, streamUntilFailed(make(chan error, 1)), WithTransportName("missing")); err == nil {
+			t.Fatal("cl.Post with an unknown transport name succeeded")
+		}
+	}
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > goroutines && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := runtime.NumGoroutine(); got > goroutines {
+		t.Errorf("%d goroutines after rejecting the requests, want at most %d", got, goroutines)
+	}
+}
+
+func TestPreserveAuthOnRedirect(t *testing.T) {
+	c := context.Background()
+	const auth = "Bearer secret"
+
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get(AuthorizationHeader); got != "" {
+			t.Errorf("cross-host redirect sent %s = %q, want it stripped", AuthorizationHeader, got)
+		}
+	}))
+	defer other.Close()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/same":
+			http.Redirect(w, r, "/target", http.StatusFound)
+		case "/cross":
+			http.Redirect(w, r, other.URL+"/target", http.StatusFound)
+		case "/target":
+			if got := r.Header.Get(AuthorizationHeader); got != auth {
+				t.Errorf("same-host redirect sent %s = %q, want %q", AuthorizationHeader, got, auth)
+			}
+		}
+	}))
+	defer ts.Close()
+
+	cl, err := NewClient(c, WithPreserveAuthOnRedirect())
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	for _, path := range []string{"/same", "/cross"} {
+		resp, err := cl.Get(c, ts.URL+path, WithHeader(AuthorizationHeader, auth))
+		if err != nil {
+			t.Fatalf("cl.Get %s failed: %v", path, err)
+		}
+		resp.Close()
+	}
+
+	tests := []struct {
+		from, to string
+		want     bool
+	}{
+		{"http://example.com/a", "http://EXAMPLE.com/b", true},
+		{"http://example.com/a", "https://example.com/b", true},
+		{"http://example.com:8080/a", "http://example.com:8080/b", true},
+		{"https://example.com/a", "http://example.com/b", false},
+		{"http://example.com/a", "http://example.com:8080/b", false},
+		{"http://example.com/a", "http://api.example.com/b", false},
+		{"http://example.com/a", "http://example.org/b", false},
+	}
+	for _, tt := range tests {
+		from, _ := url.Parse(tt.from)
+		to, _ := url.Parse(tt.to)
+		if got := isSameHostRedirect(from, to); got != tt.want {
+			t.Errorf("isSameHostRedirect(%s, %s) = %t, want %t", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestReadTimeout(t *testing.T) {
+	c := context.Background()
+	stall := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{"))
+		w.(http.Flusher).Flush()
+		select {
+		case <-stall:
+		case <-r.Context().Done():
+		}
+	}))
+	defer ts.Close()
+	defer close(stall)
+
+	cl, err := NewClient(c, WithReadTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	start := time.Now()
+	resp, err := cl.Get(c, ts.URL)
+	if err != nil {
+		t.Fatalf("cl.Get failed: %v", err)
+	}
+	_, err = resp.Bytes()
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("Bytes err = %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("stalled read took %s, want it to fail after the read timeout", elapsed)
+	}
+}
+
+func TestNewRequestFromHTTP(t *testing.T) {
+	c := context.Background()
+	const payload = `{"URL":"https://nozzle.io/","Count":30}`
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("upstream method = %s, want %s", r.Method, http.MethodPost)
+		}
+		if r.URL.Path != "/api/items" || r.URL.RawQuery != "a=1" {
+			t.Errorf("upstream url = %s, want /api/items?a=1", r.URL)
+		}
+		if got := r.Header.Get("X-Request-Id"); got != "abc" {
+			t.Errorf("upstream X-Request-Id = %q, want abc", got)
+		}
+		if got := r.Header.Get("X-Hop"); got != "" {
+			t.Errorf("upstream X-Hop = %q, want the Connection listed header stripped", got)
+		}
+		if got := r.Header.Get("X-Forwarded-By"); got != "fetcher" {
+			t.Errorf("upstream X-Forwarded-By = %q, want fetcher", got)
+		}
+		if r.ContentLength != int64(len(payload)) {
+			t.Errorf("upstream ContentLength = %d, want %d", r.ContentLength, len(payload))
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("reading upstream body failed: %v", err)
+			return
+		}
+		if string(body) != payload {
+			t.Errorf("upstream body = %s, want %s", body, payload)
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer upstream.Close()
+
+	cl, err := NewClient(c)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, err := cl.NewRequestFromHTTP(r.Context(), r,
+			WithBaseURL(upstream.URL+"/api"),
+			WithHeader("X-Forwarded-By", "fetcher"),
+		)
+		if err != nil {
+			t.Errorf("NewRequestFromHTTP failed: %v", err)
+			return
+		}
+		resp, err := cl.Do(r.Context(), req)
+		if err != nil {
+			t.Errorf("cl.Do failed: %v", err)
+			return
+		}
+		resp.Pipe(w, true)
+	}))
+	defer proxy.Close()
+
+	resp, err := cl.Post(c, proxy.URL+"/items?a=1",
+		WithBody(payload, ContentTypeJSON),
+		WithHeader("X-Request-Id", "abc"),
+		WithHeader("Connection", "X-Hop"),
+		WithHeader("X-Hop", "1"),
+	)
+	if err != nil {
+		t.Fatalf("cl.Post failed: %v", err)
+	}
+	defer resp.Close()
+	if resp.StatusCode() != http.StatusCreated {
+		t.Errorf("StatusCode = %d, want %d", resp.StatusCode(), http.StatusCreated)
+	}
+}
+
+func TestGzipPayload(t *testing.T) {
+	c := context.Background()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ce := r.Header.Get(ContentEncodingHeader); ce != "gzip" {
+			t.Errorf("Content-Encoding = %q, want gzip", ce)
+		}
+		gr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Errorf("gzip.NewReader failed: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body, err := ioutil.ReadAll(gr)
+		if err != nil {
+			t.Errorf("decompressing payload failed: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		// echo the decompressed payload
+		w.Header().Set(ContentTypeHeader, ContentTypeJSON)
+		w.Write(body)
+	}))
+	defer ts.Close()
+
+	cl, err := NewClient(c)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		payload interface{}
+	}{
+		{"empty object", struct{}{}},
+		{"small", testObject{URL: "https://nozzle.io/", Count: 1}},
+		{"large", func() []testObject {
+			objs := make([]testObject, 10000)
+			for i := range objs {
+				objs[i] = testObject{URL: fmt.Sprintf("https://nozzle.io/%d", i), Count: i}
+			}
+			return objs
+		}()},
+	}
+	for _, tt := range tests {
+		want, err := json.Marshal(tt.payload)
+		if err != nil {
+			t.Fatalf("%s: json.Marshal failed: %v", tt.name, err)
+		}
+
+		resp, err := cl.Post(c, ts.URL, WithJSONPayload(tt.payload), WithGzipPayload())
+		if err != nil {
+			t.Fatalf("%s: cl.Post failed: %v", tt.name, err)
+		}
+		got, err := resp.Bytes()
+		if err != nil {
+			t.Fatalf("%s: Bytes failed: %v", tt.name, err)
+		}
+		if !bytes.Equal(bytes.TrimSpace(got), want) {
+			t.Errorf("%s: echoed payload = %.100s, want %.100s", tt.name, got, want)
+		}
+	}
+}
+
+func TestDeadlineHeader(t *testing.T) {
+	const header = "X-Timeout-Ms"
+	var mu sync.Mutex
+	var budgets []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		budgets = append(budgets, r.Header.Get(header))
+		attempt := len(budgets)
+		mu.Unlock()
+		if attempt == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+
+	c, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	cl, err := NewClient(c)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	resp, err := cl.Get(c, ts.URL,
+		WithDeadlineHeader(header),
+		WithMaxAttempts(2),
+		WithNoBackoff(200*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatalf("cl.Get failed: %v", err)
+	}
+	resp.Close()
+
+	if len(budgets) != 2 {
+		t.Fatalf("attempts = %d, want 2", len(budgets))
+	}
+	var first, second int
+	fmt.Sscan(budgets[0], &first)
+	fmt.Sscan(budgets[1], &second)
+	if first <= 4000 || first > 5000 {
+		t.Errorf("first attempt %s = %q, want between 4000 and 5000", header, budgets[0])
+	}
+	if second <= 0 || second > first-200 {
+		t.Errorf("second attempt %s = %q, want the budget left after the 200ms backoff (first was %d)", header, budgets[1], first)
+	}
+
+	// without a deadline the header isn't sent
+	resp, err = cl.Get(context.Background(), ts.URL, WithDeadlineHeader(header))
+	if err != nil {
+		t.Fatalf("cl.Get failed: %v", err)
+	}
+	resp.Close()
+	if got := budgets[len(budgets)-1]; got != "" {
+		t.Errorf("%s without a deadline = %q, want it unset", header, got)
+	}
+}
+
+func TestRefreshOn401(t *testing.T) {
+	c := context.Background()
+	var hits, refreshes int32
+	var authorized int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		body, _ := ioutil.ReadAll(r.Body)
+		if string(body) != "payload" {
+			t.Errorf("body = %q, want payload to be resent", body)
+		}
+		if r.URL.Path == "/always" || atomic.LoadInt32(&authorized) == 0 {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer ts.Close()
+
+	cl, err := NewClient(c)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	refresh := func(c context.Context) error {
+		atomic.AddInt32(&refreshes, 1)
+		atomic.StoreInt32(&authorized, 1)
+		return nil
+	}
+
+	tests := []struct {
+		path          string
+		wantStatus    int
+		wantHits      int32
+		wantRefreshes int32
+	}{
+		{"/refresh", http.StatusOK, 2, 1},
+		// a repeated 401 is returned after a single refresh
+		{"/always", http.StatusUnauthorized, 2, 1},
+	}
+	for _, tt := range tests {
+		atomic.StoreInt32(&hits, 0)
+		atomic.StoreInt32(&refreshes, 0)
+		atomic.StoreInt32(&authorized, 0)
+
+		resp, err := cl.Post(c, ts.URL+tt.path, WithBody("payload", ContentTypeJSON), WithRefreshOn401(refresh))
+		if err != nil {
+			t.Fatalf("%s: cl.Post failed: %v", tt.path, err)
+		}
+		resp.Close()
+		if resp.StatusCode() != tt.wantStatus {
+			t.Errorf("%s: StatusCode = %d, want %d", tt.path, resp.StatusCode(), tt.wantStatus)
+		}
+		if hits != tt.wantHits {
+			t.Errorf("%s: hits = %d, want %d", tt.path, hits, tt.wantHits)
+		}
+		if refreshes != tt.wantRefreshes {
+			t.Errorf("%s: refreshes = %d, want %d", tt.path, refreshes, tt.wantRefreshes)
+		}
+	}
+
+	// an error from the refresh func is returned
+	errRefresh := errors.New("refresh failed")
+	_, err = cl.Post(c, ts.URL+"/always", WithBody("payload", ContentTypeJSON), WithRefreshOn401(func(c context.Context) error {
+		return errRefresh
+	}))
+	if !errors.Is(err, errRefresh) {
+		t.Errorf("cl.Post err = %v, want %v", err, errRefresh)
+	}
+}
+
+func TestDisableCompression(t *testing.T) {
+	c := context.Background()
+	want := `{"URL":"https://nozzle.io/","Count":30}`
+
+	// gzip the body only when the client asks for it, like most servers
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(ContentTypeHeader, ContentTypeJSON)
+		if !strings.Contains(r.Header.Get(AcceptEncodingHeader), "gzip") && r.URL.Path != "/always" {
+			w.Write([]byte(want))
+			return
+		}
+		w.Header().Set(ContentEncodingHeader, "gzip")
+		gw := gzip.NewWriter(w)
+		gw.Write([]byte(want))
+		gw.Close()
+	}))
+	defer ts.Close()
+
+	// the default transport asks for gzip and decompresses it transparently
+	cl, err := NewClient(c)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	resp, err := cl.Get(c, ts.URL)
+	if err != nil {
+		t.Fatalf("cl.Get failed: %v", err)
+	}
+	if got := string(resp.MustBytes()); got != want {
+		t.Errorf("default body = %q, want %q", got, want)
+	}
+
+	cl, err = NewClient(c, WithDisableCompression())
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	resp, err = cl.Get(c, ts.URL)
+	if err != nil {
+		t.Fatalf("cl.Get failed: %v", err)
+	}
+	if got := string(resp.MustBytes()); got != want {
+		t.Errorf("uncompressed body = %q, want %q", got, want)
+	}
+
+	// a gzip body is left compressed
+	resp, err = cl.Get(c, ts.URL+"/always")
+	if err != nil {
+		t.Fatalf("cl.Get failed: %v", err)
+	}
+	if got := resp.Header().Get(ContentEncodingHeader); got != "gzip" {
+		t.Errorf("%s = %q, want gzip", ContentEncodingHeader, got)
+	}
+	gr, err := gzip.NewReader(bytes.NewReader(resp.MustBytes()))
+	if err != nil {
+		t.Fatalf("body isn't gzip compressed: %v", err)
+	}
+	if got, _ := ioutil.ReadAll(gr); string(got) != want {
+		t.Errorf("decompressed body = %q, want %q", got, want)
+	}
+
+	// and WithDecompression still decompresses it explicitly
+	resp, err = cl.Get(c, ts.URL, WithDecompression("gzip"))
+	if err != nil {
+		t.Fatalf("cl.Get failed: %v", err)
+	}
+	if got := string(resp.MustBytes()); got != want {
+		t.Errorf("explicitly decompressed body = %q, want %q", got, want)
+	}
+}
+
+func TestClientAfterDoFunc(t *testing.T) {
+	c := context.Background()
+	ts := testServerHelper(t, &serverData{statusCode: http.StatusTeapot})
+	defer ts.Close()
+
+	var mu sync.Mutex
+	var calls []string
+	record := func(name string) func(req *Request, resp *Response) error {
+		return func(req *Request, resp *Response) error {
+			mu.Lock()
+			defer mu.Unlock()
+			calls = append(calls, fmt.Sprintf("%s:%d", name, resp.StatusCode()))
+			return nil
+		}
+	}
+
+	cl, err := NewClient(c,
+		WithClientAfterDoFunc(record("client1")),
+		WithClientAfterDoFunc(record("client2")),
+	)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	resp, err := cl.Get(c, ts.URL, WithAfterDoFunc(record("request")))
+	if err != nil {
+		t.Fatalf("cl.Get failed: %v", err)
+	}
+	resp.Close()
+	resp, err = cl.Get(c, ts.URL)
+	if err != nil {
+		t.Fatalf("cl.Get failed: %v", err)
+	}
+	resp.Close()
+
+	want := []string{"client1:418", "client2:418", "request:418", "client1:418", "client2:418"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %v, want %v", calls, want)
+	}
+
+	// an error from a client afterDoFunc is returned by Do
+	errHook := errors.New("hook failed")
+	cl, err = NewClient(c, WithClientAfterDoFunc(func(req *Request, resp *Response) error {
+		return errHook
+	}))
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	if _, err = cl.Get(c, ts.URL); !errors.Is(err, errHook) {
+		t.Errorf("cl.Get err = %v, want %v", err, errHook)
+	}
+}
+
+func TestDrainOnClose(t *testing.T) {
+	c := context.Background()
+	body := bytes.Repeat([]byte("a"), 512<<10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", fmt.Sprint(len(body)))
+		w.Write(body)
+	}))
+	defer ts.Close()
+
+	// the body is larger than the default, and than newer http.Transports drain themselves
+	tests := []struct {
+		name       string
+		opts       []ClientOption
+		wantReused int
+	}{
+		{"default", nil, 0},
+		{"disabled", []ClientOption{WithDrainOnClose(0)}, 0},
+		{"raised limit", []ClientOption{WithDrainOnClose(1 << 20)}, 2},
+	}
+	for _, tt := range tests {
+		cl, err := NewClient(c, tt.opts...)
+		if err != nil {
+			t.Fatalf("%s: NewClient failed: %v", tt.name, err)
+		}
+
+		// close each response without reading the body
+		var reused int
+		trace := &httptrace.ClientTrace{
+			GotConn: func(info httptrace.GotConnInfo) {
+				if info.Reused {
+					reused++
+				}
+			},
+		}
+		for i := 0; i < 3; i++ {
+			resp, err := cl.Get(c, ts.URL, WithClientTrace(trace))
+			if err != nil {
+				t.Fatalf("%s: cl.Get failed: %v", tt.name, err)
+			}
+			resp.Close()
+		}
+		if reused != tt.wantReused {
+			t.Errorf("%s: reused connections = %d, want %d", tt.name, reused, tt.wantReused)
+		}
+	}
+}
+
+func TestMaxResponseHeaderBytes(t *testing.T) {
+	c := context.Background()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Large", strings.Repeat("a", 8<<10))
+	}))
+	defer ts.Close()
+
+	cl, err := NewClient(c, WithMaxResponseHeaderBytes(4<<10))
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	_, err = cl.Get(c, ts.URL)
+	if err == nil || !strings.Contains(err.Error(), "header") {
+		t.Errorf("cl.Get err = %v, want a response headers too large error", err)
+	}
+
+	cl, err = NewClient(c, WithMaxResponseHeaderBytes(16<<10))
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	resp, err := cl.Get(c, ts.URL)
+	if err != nil {
+		t.Fatalf("cl.Get under the limit failed: %v", err)
+	}
+	resp.Close()
+}
+
+// roundTripperFunc adapts a func to an http.RoundTripper
+type roundTripperFunc func(r *http.Request) (*http.Response, error)
+
+func (fn roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return fn(r)
+}
+
+func TestTransportName(t *testing.T) {
+	c := context.Background()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("X-Transport")))
+	}))
+	defer ts.Close()
+
+	// each transport tags the request, so the server echoes which one sent it
+	taggingTransport := func(name string) http.RoundTripper {
+		transport := &http.Transport{}
+		return roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			r = r.Clone(r.Context())
+			r.Header.Set("X-Transport", name)
+			return transport.RoundTrip(r)
+		})
+	}
+	cl, err := NewClient(c, WithClientTransports(map[string]http.RoundTripper{
+		"http1": taggingTransport("http1"),
+		"mtls":  taggingTransport("mtls"),
+	}))
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	tests := []struct {
+		opts []RequestOption
+		want string
+	}{
+		{nil, ""},
+		{[]RequestOption{WithTransportName("http1")}, "http1"},
+		{[]RequestOption{WithTransportName("mtls")}, "mtls"},
+	}
+	for _, tt := range tests {
+		resp, err := cl.Get(c, ts.URL, tt.opts...)
+		if err != nil {
+			t.Fatalf("cl.Get failed: %v", err)
+		}
+		if got := string(resp.MustBytes()); got != tt.want {
+			t.Errorf("sent with transport %q, want %q", got, tt.want)
+		}
+	}
+
+	if _, err = cl.Get(c, ts.URL, WithTransportName("missing")); err == nil {
+		t.Error("cl.Get with an unregistered transport: err = nil, want an error")
+	}
+}
+
+func TestClientMaxResponseBytes(t *testing.T) {
+	c := context.Background()
+	const limit = 1 << 10
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/exact":
+			w.Write(bytes.Repeat([]byte("a"), limit))
+		case "/over":
+			w.Write(bytes.Repeat([]byte("a"), limit+1))
+		case "/json":
+			w.Header().Set(ContentTypeHeader, ContentTypeJSON)
+			json.NewEncoder(w).Encode(testObject{URL: strings.Repeat("a", limit)})
+		case "/gzip":
+			// small compressed, but over the limit once decompressed
+			w.Header().Set(ContentEncodingHeader, "gzip")
+			gw := gzip.NewWriter(w)
+			gw.Write(bytes.Repeat([]byte("a"), 4*limit))
+			gw.Close()
+		}
+	}))
+	defer ts.Close()
+
+	cl, err := NewClient(c, WithClientMaxResponseBytes(limit))
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	resp, err := cl.Get(c, ts.URL+"/exact")
+	if err != nil {
+		t.Fatalf("cl.Get failed: %v", err)
+	}
+	if body, err := resp.Bytes(); err != nil || len(body) != limit {
+		t.Errorf("Bytes at the limit = %d bytes, %v, want %d bytes and no error", len(body), err, limit)
+	}
+
+	for _, path := range []string{"/over", "/gzip"} {
+		resp, err = cl.Get(c, ts.URL+path, WithDecompression("gzip"))
+		if err != nil {
+			t.Fatalf("%s: cl.Get failed: %v", path, err)
+		}
+		if _, err = resp.Bytes(); !errors.Is(err, ErrResponseTooLarge) {
+			t.Errorf("%s: Bytes err = %v, want %v", path, err, ErrResponseTooLarge)
+		}
+		if !resp.bodyClosed {
+			t.Errorf("%s: body left open after Bytes failed, want it closed to release the connection", path)
+		}
+		resp.Close()
+	}
+
+	resp, err = cl.Get(c, ts.URL+"/json")
+	if err != nil {
+		t.Fatalf("cl.Get failed: %v", err)
+	}
+	var obj testObject
+	if err = resp.Decode(c, &obj); !errors.Is(err, ErrResponseTooLarge) {
+		t.Errorf("Decode err = %v, want %v", err, ErrResponseTooLarge)
+	}
+	resp.Close()
+}
+
+func TestAccept(t *testing.T) {
+	c := context.Background()
+	want := testObject{URL: "https://nozzle.io/", Count: 30}
+
+	// a minimal negotiating server, responding with the first of its types the client asks for
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accept := r.Header.Get(AcceptHeader)
+		for _, mediaRange := range strings.Split(accept, ",") {
+			switch normalizeContentType(mediaRange) {
+			case ContentTypeXML:
+				w.Header().Set(ContentTypeHeader, ContentTypeXML)
+				xml.NewEncoder(w).Encode(want)
+				return
+			case ContentTypeJSON:
+				w.Header().Set(ContentTypeHeader, ContentTypeJSON)
+				json.NewEncoder(w).Encode(want)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotAcceptable)
+	}))
+	defer ts.Close()
+
+	cl, err := NewClient(c)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	tests := []struct {
+		types           []string
+		wantAccept      string
+		wantContentType string
+	}{
+		{[]string{ContentTypeJSON, ContentTypeXML}, "application/json,application/xml;q=0.9", ContentTypeJSON},
+		{[]string{ContentTypeXML, ContentTypeJSON}, "application/xml,application/json;q=0.9", ContentTypeXML},
+	}
+	for _, tt := range tests {
+		req, err := cl.NewRequest(c, http.MethodGet, ts.URL, WithAccept(tt.types...))
+		if err != nil {
+			t.Fatalf("%v: NewRequest failed: %v", tt.types, err)
+		}
+		if got := req.Header().Get(AcceptHeader); got != tt.wantAccept {
+			t.Errorf("%v: %s = %q, want %q", tt.types, AcceptHeader, got, tt.wantAccept)
+		}
+
+		resp, err := cl.Do(c, req)
+		if err != nil {
+			t.Fatalf("%v: cl.Do failed: %v", tt.types, err)
+		}
+		if got := resp.ContentType(); got != tt.wantContentType {
+			t.Errorf("%v: ContentType = %s, want %s", tt.types, got, tt.wantContentType)
+		}
+		var got testObject
+		if err = resp.Decode(c, &got); err != nil {
+			t.Fatalf("%v: Decode failed: %v", tt.types, err)
+		}
+		if got != want {
+			t.Errorf("%v: decoded %+v, want %+v", tt.types, got, want)
+		}
+	}
+}
+
+func TestRedirected(t *testing.T) {
+	c := context.Background()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/old" {
+			http.Redirect(w, r, "/new?a=1", http.StatusMovedPermanently)
+		}
+	}))
+	defer ts.Close()
+
+	cl, err := NewClient(c)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	tests := []struct {
+		path           string
+		wantRedirected bool
+		wantFinal      string
+	}{
+		{"/old", true, ts.URL + "/new?a=1"},
+		{"/new", false, ts.URL + "/new?a=1"},
+	}
+	for _, tt := range tests {
+		resp, err := cl.Get(c, tt.path, WithBaseURL(ts.URL), WithParam("a", "1"))
+		if err != nil {
+			t.Fatalf("%s: cl.Get failed: %v", tt.path, err)
+		}
+		resp.Close()
+		if resp.Redirected() != tt.wantRedirected {
+			t.Errorf("%s: Redirected = %t, want %t", tt.path, resp.Redirected(), tt.wantRedirected)
+		}
+		// the original url is the one given, before the base url and params were added
+		if got := resp.RequestURL(); got != tt.path {
+			t.Errorf("%s: RequestURL = %s, want %s", tt.path, got, tt.path)
+		}
+		if got := resp.FinalURL().String(); got != tt.wantFinal {
+			t.Errorf("%s: FinalURL = %s, want %s", tt.path, got, tt.wantFinal)
+		}
+	}
+}
+
+func TestMultipartPart(t *testing.T) {
+	c := context.Background()
+	png := []byte("\x89PNG\r\n\x1a\nnot really")
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Errorf("ParseMultipartForm failed: %v", err)
+			return
+		}
+		if got := r.FormValue("title"); got != "logo" {
+			t.Errorf("title field = %q, want logo", got)
+		}
+
+		files := []struct {
+			field, filename, contentType string
+			data                         []byte
+		}{
+			{"image", "logo.png", "image/png", png},
+			{"notes", "notes.txt", "application/octet-stream", []byte("plain notes")},
+		}
+		for _, want := range files {
+			fhs := r.MultipartForm.File[want.field]
+			if len(fhs) != 1 {
+				t.Errorf("%s: got %d files, want 1", want.field, len(fhs))
+				continue
+			}
+			if fhs[0].Filename != want.filename {
+				t.Errorf("%s: filename = %q, want %q", want.field, fhs[0].Filename, want.filename)
+			}
+			if got := fhs[0].Header.Get(ContentTypeHeader); got != want.contentType {
+				t.Errorf("%s: part Content-Type = %q, want %q", want.field, got, want.contentType)
+			}
+			f, err := fhs[0].Open()
+			if err != nil {
+				t.Errorf("%s: opening part failed: %v", want.field, err)
+				continue
+			}
+			data, _ := ioutil.ReadAll(f)
+			f.Close()
+			if !bytes.Equal(data, want.data) {
+				t.Errorf("%s: part data = %q, want %q", want.field, data, want.data)
+			}
+		}
+	}))
+	defer ts.Close()
+
+	cl, err := NewClient(c)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	// the parts compose into one payload, with the fields first whatever the option order
+	resp, err := cl.Post(c, ts.URL,
+		WithMultipartPart("image", "logo.png", "image/png", bytes.NewReader(png)),
+		WithReaderMultipartPayload("notes", "notes.txt", strings.NewReader("plain notes")),
+		WithMultipartField("title", "logo"),
+	)
+	if err != nil {
+		t.Fatalf("cl.Post failed: %v", err)
+	}
+	resp.Close()
+}
+
+func TestBytesPartial(t *testing.T) {
+	c := context.Background()
+	first := bytes.Repeat([]byte("a"), 1<<10)
+	stall := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(first)
+		if r.URL.Path == "/complete" {
+			return
+		}
+		w.(http.Flusher).Flush()
+		select {
+		case <-stall:
+		case <-r.Context().Done():
+		}
+	}))
+	defer ts.Close()
+	defer close(stall)
+
+	cl, err := NewClient(c)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	resp, err := cl.Get(c, ts.URL+"/stall")
+	if err != nil {
+		t.Fatalf("cl.Get failed: %v", err)
+	}
+	readC, cancel := context.WithTimeout(c, 100*time.Millisecond)
+	defer cancel()
+	got, truncated, err := resp.BytesPartial(readC)
+	if !truncated || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("BytesPartial truncated = %t, err = %v, want true and %v", truncated, err, context.DeadlineExceeded)
+	}
+	if !bytes.Equal(got, first) {
+		t.Errorf("BytesPartial returned %d bytes, want the %d sent before the stall", len(got), len(first))
+	}
+	resp.Close()
+
+	resp, err = cl.Get(c, ts.URL+"/complete")
+	if err != nil {
+		t.Fatalf("cl.Get failed: %v", err)
+	}
+	got, truncated, err = resp.BytesPartial(c)
+	if truncated || err != nil || !bytes.Equal(got, first) {
+		t.Errorf("BytesPartial = %d bytes, truncated %t, err %v, want all %d bytes", len(got), truncated, err, len(first))
+	}
+	resp.Close()
+}
+
+func TestLocalAddr(t *testing.T) {
+	c := context.Background()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.RemoteAddr))
+	}))
+	defer ts.Close()
+
+	// bind to a fixed port, since every loopback address is local
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen failed: %v", err)
+	}
+	localAddr := l.Addr().(*net.TCPAddr)
+	l.Close()
+
+	cl, err := NewClient(c, WithLocalAddr(localAddr))
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	resp, err := cl.Get(c, ts.URL)
+	if err != nil {
+		t.Fatalf("cl.Get failed: %v", err)
+	}
+	if got := string(resp.MustBytes()); got != localAddr.String() {
+		t.Errorf("server saw the request from %s, want %s", got, localAddr)
+	}
+}
+
+func TestNonceHeader(t *testing.T) {
+	c := context.Background()
+	const header = "X-Nonce"
+	var mu sync.Mutex
+	var nonces []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		nonces = append(nonces, r.Header.Get(header))
+		attempt := len(nonces)
+		mu.Unlock()
+		if attempt < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+
+	cl, err := NewClient(c)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	var generated int32
+	resp, err := cl.Get(c, ts.URL,
+		WithNonceHeader(header, func() string {
+			return fmt.Sprintf("nonce-%d", atomic.AddInt32(&generated, 1))
+		}),
+		WithMaxAttempts(3),
+		WithNoBackoff(time.Millisecond),
+	)
+	if err != nil {
+		t.Fatalf("cl.Get failed: %v", err)
+	}
+	resp.Close()
+
+	want := []string{"nonce-1", "nonce-2", "nonce-3"}
+	if !reflect.DeepEqual(nonces, want) {
+		t.Errorf("nonces = %v, want a new one per attempt %v", nonces, want)
+	}
+}
+
+func TestPipeFlushes(t *testing.T) {
+	// a proxy that doesn't flush holds the events back, failing the reads once the context is done
+	c, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	next := make(chan struct{}, 3)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(ContentTypeHeader, "text/event-stream")
+		for i := 1; i <= 3; i++ {
+			fmt.Fprintf(w, "data: %d\n\n", i)
+			w.(http.Flusher).Flush()
+			select {
+			case <-next:
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}))
+	defer upstream.Close()
+
+	cl, err := NewClient(c)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp, err := cl.Get(r.Context(), upstream.URL)
+		if err != nil {
+			t.Errorf("cl.Get failed: %v", err)
+			return
+		}
+		resp.Pipe(w, true)
+	}))
+	defer proxy.Close()
+
+	resp, err := cl.Get(c, proxy.URL)
+	if err != nil {
+		t.Fatalf("cl.Get failed: %v", err)
+	}
+	defer resp.Close()
+
+	// each event arrives through the proxy before the upstream sends the next one
+	body := bufio.NewReader(resp.Body())
+	for i := 1; i <= 3; i++ {
+		line, err := body.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading event %d failed: %v", i, err)
+		}
+		if want := fmt.Sprintf("data: %d\n", i); line != want {
+			t.Errorf("event %d = %q, want %q", i, line, want)
+		}
+		body.ReadString('\n')
+		next <- struct{}{}
+	}
+}
+
+func TestCloseIdleConnections(t *testing.T) {
+	c := context.Background()
+	ts := testServerHelper(t, &serverData{statusCode: http.StatusOK})
+	defer ts.Close()
+
+	cl, err := NewClient(c)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	var reused []bool
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			reused = append(reused, info.Reused)
+		},
+	}
+	get := func() {
+		resp, err := cl.Get(c, ts.URL, WithClientTrace(trace))
+		if err != nil {
+			t.Fatalf("cl.Get failed: %v", err)
+		}
+		resp.Close()
+	}
+
+	get()
+	get()
+	cl.CloseIdleConnections()
+	get()
+
+	if want := []bool{false, true, false}; !reflect.DeepEqual(reused, want) {
+		t.Errorf("connections reused = %v, want %v", reused, want)
+	}
+}
+
+func TestHedging(t *testing.T) {
+	c := context.Background()
+	var hits int32
+	firstCancelled := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) == 1 {
+			// the first request stalls until the hedge wins and cancels it
+			select {
+			case <-r.Context().Done():
+				close(firstCancelled)
+			case <-time.After(5 * time.Second):
+			}
+			return
+		}
+		w.Write([]byte("hedge"))
+	}))
+	defer ts.Close()
+
+	cl, err := NewClient(c)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	start := time.Now()
+	resp, err := cl.Get(c, ts.URL, WithHedging(50*time.Millisecond, 2))
+	if err != nil {
+		t.Fatalf("cl.Get failed: %v", err)
+	}
+	defer resp.Close()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("hedged request took %s, want the hedge's response without waiting for the first", elapsed)
+	}
+	b, err := resp.Bytes()
+	if err != nil {
+		t.Fatalf("resp.Bytes failed: %v", err)
+	}
+	if string(b) != "hedge" {
+		t.Errorf("body = %q, want %q", b, "hedge")
+	}
+	select {
+	case <-firstCancelled:
+	case <-time.After(time.Second):
+		t.Error("the first request wasn't cancelled after the hedge won")
+	}
+	if got := atomic.LoadInt32(&hits); got != 2 {
+		t.Errorf("server got %d requests, want 2", got)
+	}
+
+	if _, err = cl.Post(c, ts.URL, WithHedging(50*time.Millisecond, 1)); err == nil {
+		t.Error("hedging a POST succeeded, want an error for a non-idempotent method")
+	}
+}
+
+func TestHedgingPayload(t *testing.T) {
+	c := context.Background()
+	want := testObject{URL: "https://nozzle.io/", Count: 30}
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var got testObject
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil || got != want {
+			t.Errorf("request body = %+v (err %v), want %+v", got, err, want)
+		}
+		if atomic.AddInt32(&hits, 1) == 1 {
+			<-r.Context().Done()
+		}
+	}))
+	defer ts.Close()
+
+	cl, err := NewClient(c)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	// every hedge sends its own copy of the pooled payload, which is released once Do returns
+	resp, err := cl.Put(c, ts.URL, WithJSONPayload(want), WithHedging(20*time.Millisecond, 1))
+	if err != nil {
+		t.Fatalf("cl.Put failed: %v", err)
+	}
+	resp.Close()
+	for i := 0; i < 10; i++ {
+		resp, err = cl.Put(c, ts.URL, WithJSONPayload(want))
+		if err != nil {
+			t.Fatalf("cl.Put failed: %v", err)
+		}
+		resp.Close()
+	}
+}
+
+func TestResponseDuration(t *testing.T) {
+	c := context.Background()
+	const delay = 100 * time.Millisecond
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay)
+	}))
+	defer ts.Close()
+
+	var observed time.Duration
+	cl, err := NewClient(c, WithClientAfterDoFunc(func(req *Request, resp *Response) error {
+		observed = resp.Duration()
+		return nil
+	}))
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	resp, err := cl.Get(c, ts.URL)
+	if err != nil {
+		t.Fatalf("cl.Get failed: %v", err)
+	}
+	defer resp.Close()
+	if d := resp.Duration(); d < delay {
+		t.Errorf("Duration() = %s, want at least the server delay %s", d, delay)
+	}
+	if observed != resp.Duration() {
+		t.Errorf("afterDoFunc saw Duration() = %s, want %s", observed, resp.Duration())
+	}
+}
+
+func TestAttemptHistory(t *testing.T) {
+	c := context.Background()
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch atomic.AddInt32(&hits, 1) {
+		case 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 2:
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer ts.Close()
+
+	cl, err := NewClient(c)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	resp, err := cl.Get(c, ts.URL, WithMaxAttempts(3), WithNoBackoff(time.Millisecond))
+	if err != nil {
+		t.Fatalf("cl.Get failed: %v", err)
+	}
+	defer resp.Close()
+
+	want := []AttemptRecord{
+		{StatusCode: http.StatusServiceUnavailable, Delay: time.Millisecond},
+		{StatusCode: http.StatusBadGateway, Delay: time.Millisecond},
+		{StatusCode: http.StatusOK},
+	}
+	if got := resp.AttemptHistory(); !reflect.DeepEqual(got, want) {
+		t.Errorf("AttemptHistory() = %+v, want %+v", got, want)
+	}
+}
+
+func TestRetryOnHeader(t *testing.T) {
+	c := context.Background()
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hit := atomic.AddInt32(&hits, 1)
+		if hit <= 2 {
+			w.Header().Set("X-Retry", "true")
+		}
+		fmt.Fprintf(w, "attempt %d", hit)
+	}))
+	defer ts.Close()
+
+	cl, err := NewClient(c)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	resp, err := cl.Get(c, ts.URL, WithRetryOnHeader("x-retry", "TRUE"), WithMaxAttempts(5), WithNoBackoff(time.Millisecond))
+	if err != nil {
+		t.Fatalf("cl.Get failed: %v", err)
+	}
+	if got, want := string(resp.MustBytes()), "attempt 3"; got != want {
+		t.Errorf("body = %q, want %q", got, want)
+	}
+	if got := atomic.LoadInt32(&hits); got != 3 {
+		t.Errorf("server got %d requests, want 3", got)
+	}
+
+	// once out of attempts, the last response is returned still readable
+	atomic.StoreInt32(&hits, 0)
+	resp, err = cl.Get(c, ts.URL, WithRetryOnHeader("X-Retry", "true"), WithMaxAttempts(2), WithNoBackoff(time.Millisecond))
+	if err != nil {
+		t.Fatalf("cl.Get failed: %v", err)
+	}
+	if got, want := string(resp.MustBytes()), "attempt 2"; got != want {
+		t.Errorf("body = %q, want %q", got, want)
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	c := context.Background()
+	type page struct {
+		Items []int  `json:"items"`
+		Next  string `json:"next"`
+	}
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Header().Set(ContentTypeHeader, ContentTypeJSON)
+		switch r.URL.Query().Get("page") {
+		case "":
+			json.NewEncoder(w).Encode(page{Items: []int{1, 2}, Next: "/items?page=2"})
+		case "2":
+			json.NewEncoder(w).Encode(page{Items: []int{3, 4}, Next: "/items?page=3"})
+		default:
+			json.NewEncoder(w).Encode(page{Items: []int{5}})
+		}
+	}))
+	defer ts.Close()
+
+	cl, err := NewClient(c)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	var items []int
+	var current page
+	err = cl.Paginate(c, ts.URL+"/items",
+		func(resp *Response) (string, error) { return current.Next, nil },
+		func(resp *Response) error {
+			current = page{}
+			if err := resp.Decode(c, &current); err != nil {
+				return err
+			}
+			items = append(items, current.Items...)
+			return nil
+		},
+	)
+	if err != nil {
+		t.Fatalf("cl.Paginate failed: %v", err)
+	}
+	if want := []int{1, 2, 3, 4, 5}; !reflect.DeepEqual(items, want) {
+		t.Errorf("items = %v, want %v", items, want)
+	}
+
+	// cancelling the context stops before the next page is requested
+	atomic.StoreInt32(&hits, 0)
+	cc, cancel := context.WithCancel(c)
+	defer cancel()
+	err = cl.Paginate(cc, ts.URL+"/items",
+		func(resp *Response) (string, error) { return "/items?page=2", nil },
+		func(resp *Response) error {
+			cancel()
+			return nil
+		},
+	)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("cl.Paginate error = %v, want context.Canceled", err)
+	}
+	if got := atomic.LoadInt32(&hits); got != 1 {
+		t.Errorf("server got %d requests after cancelling, want 1", got)
+	}
+}
+
+type traceIDKey struct{}
+
+func TestContextHeaderFunc(t *testing.T) {
+	var got http.Header
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	}))
+	defer ts.Close()
+
+	cl, err := NewClient(context.Background(), WithRequestOptions([]RequestOption{
+		WithContextHeaderFunc(func(c context.Context) map[string]string {
+			traceID, _ := c.Value(traceIDKey{}).(string)
+			return map[string]string{"X-Trace-Id": traceID, "X-Service": "fetcher"}
+		}),
+	}))
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	c := context.WithValue(context.Background(), traceIDKey{}, "trace-123")
+	resp, err := cl.Get(c, ts.URL)
+	if err != nil {
+		t.Fatalf("cl.Get failed: %v", err)
+	}
+	resp.Close()
+	if got.Get("X-Trace-Id") != "trace-123" || got.Get("X-Service") != "fetcher" {
+		t.Errorf("headers = %v, want X-Trace-Id and X-Service from the context func", got)
+	}
+
+	// a context without the value doesn't send an empty header
+	resp, err = cl.Get(context.Background(), ts.URL)
+	if err != nil {
+		t.Fatalf("cl.Get failed: %v", err)
+	}
+	resp.Close()
+	if _, ok := got["X-Trace-Id"]; ok {
+		t.Errorf("X-Trace-Id = %q, want it unset", got.Get("X-Trace-Id"))
+	}
+}
+
+func TestCoalesce(t *testing.T) {
+	c := context.Background()
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Write(append([]byte("got "), body...))
+	}))
+	defer ts.Close()
+
+	cl, err := NewClient(c, WithCoalesce(100*time.Millisecond))
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	bodies := make([]string, 5)
+	for i := range bodies {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp, err := cl.Post(c, ts.URL, WithBytesPayload([]byte("event")))
+			if err != nil {
+				t.Errorf("cl.Post %d failed: %v", i, err)
+				return
+			}
+			b, err := resp.Bytes()
+			if err != nil {
+				t.Errorf("resp.Bytes %d failed: %v", i, err)
+			}
+			bodies[i] = string(b)
+		}(i)
+	}
+	wg.Wait()
+
+	if got := atomic.LoadInt32(&hits); got != 1 {
+		t.Errorf("server got %d requests, want the 5 identical requests merged into 1", got)
+	}
+	for i, body := range bodies {
+		if body != "got event" {
+			t.Errorf("response %d body = %q, want %q", i, body, "got event")
+		}
+	}
+
+	// a different body isn't merged
+	resp, err := cl.Post(c, ts.URL, WithBytesPayload([]byte("other")))
+	if err != nil {
+		t.Fatalf("cl.Post failed: %v", err)
+	}
+	resp.Close()
+	if got := atomic.LoadInt32(&hits); got != 2 {
+		t.Errorf("server got %d requests, want 2", got)
+	}
+}
+
+func TestTLSClientCert(t *testing.T) {
+	c := context.Background()
+
+	// a self-signed client certificate, trusted by the server
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey failed: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "fetcher-client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("x509.CreateCertificate failed: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("x509.MarshalECPrivateKey failed: %v", err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	clientCert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		t.Fatalf("x509.ParseCertificate failed: %v", err)
+	}
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	ts.StartTLS()
+	defer ts.Close()
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(ts.Certificate())
+
+	for _, withCert := range []bool{true, false} {
+		var opts []ClientOption
+		if withCert {
+			opts = append(opts, WithTLSClientCert(certPEM, keyPEM))
+		}
+		cl, err := NewClient(c, opts...)
+		if err != nil {
+			t.Fatalf("NewClient failed: %v", err)
+		}
+		// trust the test server's certificate
+		if cl.transport.TLSClientConfig == nil {
+			cl.transport.TLSClientConfig = &tls.Config{}
+		}
+		cl.transport.TLSClientConfig.RootCAs = rootCAs
+
+		resp, err := cl.Get(c, ts.URL, WithMaxAttempts(1))
+		if !withCert {
+			if err == nil {
+				resp.Close()
+				t.Error("cl.Get without a client certificate succeeded, want the handshake to fail")
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("cl.Get failed: %v", err)
+		}
+		if got := string(resp.MustBytes()); got != "fetcher-client" {
+			t.Errorf("server saw client certificate %q, want %q", got, "fetcher-client")
+		}
+	}
+
+	if _, err = NewClient(c, WithTLSClientCert(certPEM, []byte("not a key"))); err == nil {
+		t.Error("NewClient with an invalid key succeeded, want an error")
+	}
+}
+
+func TestALPN(t *testing.T) {
+	c := context.Background()
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.NegotiatedProtocol))
+	}))
+	ts.TLS = &tls.Config{NextProtos: []string{"custom/1", "http/1.1"}}
+	// the server hands connections negotiating custom/1 to its own handler, which answers a single HTTP/1.1 request
+	ts.Config.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){
+		"custom/1": func(s *http.Server, conn *tls.Conn, h http.Handler) {
+			defer conn.Close()
+			if _, err := http.ReadRequest(bufio.NewReader(conn)); err != nil {
+				t.Errorf("http.ReadRequest failed: %v", err)
+				return
+			}
+			body := conn.ConnectionState().NegotiatedProtocol
+			fmt.Fprintf(conn, "HTTP/1.1 200 OK\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s", len(body), body)
+		},
+	}
+	ts.StartTLS()
+	defer ts.Close()
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(ts.Certificate())
+
+	if _, err := NewClient(c, WithALPN("h2", "http/1.1")); err == nil {
+		t.Error("NewClient offering h2 succeeded, want an error")
+	}
+
+	cl, err := NewClient(c, WithALPN("custom/1", "http/1.1"))
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	// trust the test server's certificate
+	cl.transport.TLSClientConfig.RootCAs = rootCAs
+
+	resp, err := cl.Get(c, ts.URL)
+	if err != nil {
+		t.Fatalf("cl.Get failed: %v", err)
+	}
+	defer resp.Close()
+	if got := resp.response.TLS.NegotiatedProtocol; got != "custom/1" {
+		t.Errorf("client negotiated protocol = %q, want %q", got, "custom/1")
+	}
+	if got := string(resp.MustBytes()); got != "custom/1" {
+		t.Errorf("server negotiated protocol = %q, want %q", got, "custom/1")
+	}
+}
@@ -1,7 +1,73 @@
 package fetcher
 
-import "context"
-import "fmt"
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+	"time"
+)
+
+// redacted replaces sensitive values in logs
+const redacted = "[REDACTED]"
+
+// defaultRedactedHeaders are always redacted in String(), logs and wire dumps
+var defaultRedactedHeaders = []string{"Authorization", "Cookie", "Set-Cookie", "Proxy-Authorization"}
+
+// WithRedactHeaders adds the given header names to the headers redacted in String(), logs and wire dumps
+// Authorization, Cookie, Set-Cookie and Proxy-Authorization are always redacted
+func WithRedactHeaders(names ...string) RequestOption {
+	return func(c context.Context, req *Request) error {
+		req.redactHeaders = append(req.redactHeaders, names...)
+		return nil
+	}
+}
+
+// isRedactedHeader returns true if the values of the header key should not be logged
+func (req *Request) isRedactedHeader(key string) bool {
+	for _, name := range defaultRedactedHeaders {
+		if strings.EqualFold(key, name) {
+			return true
+		}
+	}
+	for _, name := range req.redactHeaders {
+		if strings.EqualFold(key, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactedHeaders returns a copy of req.headers with sensitive values redacted
+func (req *Request) redactedHeaders() []header {
+	if req.headers == nil {
+		return nil
+	}
+	headers := make([]header, len(req.headers))
+	for i := range req.headers {
+		headers[i] = req.headers[i]
+		if req.isRedactedHeader(headers[i].key) {
+			headers[i].value = redacted
+		}
+	}
+	return headers
+}
+
+// redactHTTPHeader returns a copy of h with sensitive values redacted
+func (req *Request) redactHTTPHeader(h http.Header) http.Header {
+	redactedHeader := make(http.Header, len(h))
+	for key, values := range h {
+		if req.isRedactedHeader(key) {
+			values = []string{redacted}
+		}
+		redactedHeader[key] = values
+	}
+	return redactedHeader
+}
 
 // LogFunc is a pluggable log function
 type LogFunc func(string)
@@ -42,6 +108,163 @@ func WithRequestErrorLogFunc(fn LogFunc) RequestOption {
 	}
 }
 
+// WireDumpFunc receives the raw wire representation of a request (isRequest == true) or response
+type WireDumpFunc func(dump []byte, isRequest bool)
+
+// WithWireDump pipes the wire representation of every request attempt and its response to the supplied function
+// NOTE: the request and response bodies are buffered in memory in order to be dumped
+func WithWireDump(fn WireDumpFunc) RequestOption {
+	return func(c context.Context, req *Request) error {
+		req.wireDumpFunc = fn
+		return nil
+	}
+}
+
+// WithLogRequestBody logs up to maxBytes of the Request payload to the debug log before it is sent
+// Only the logged prefix is buffered, so streaming payloads are not read into memory
+func WithLogRequestBody(maxBytes int) RequestOption {
+	return func(c context.Context, req *Request) error {
+		req.logPayloadMaxBytes = maxBytes
+		return nil
+	}
+}
+
+// WithSensitivePayload redacts the Request payload in String() and WithLogRequestBody logs
+func WithSensitivePayload() RequestOption {
+	return func(c context.Context, req *Request) error {
+		req.sensitivePayload = true
+		return nil
+	}
+}
+
+// capturePayloadPrefix stores up to req.logPayloadMaxBytes of the payload without consuming it
+func (req *Request) capturePayloadPrefix() error {
+	if req.logPayloadMaxBytes <= 0 || req.payload == nil || req.sensitivePayload {
+		return nil
+	}
+	// peeking a streamed payload would wait on fn before the request is sent
+	if req.optStreamingPayload {
+		return nil
+	}
+
+	var prefix []byte
+	switch v := req.payload.(type) {
+	case *bytes.Buffer:
+		prefix = v.Bytes()
+
+	// *bytes.Reader and *strings.Reader can be read from their current offset without being consumed,
+	// which keeps their Content-Length intact
+	case interface {
+		io.ReaderAt
+		Len() int
+		Size() int64
+	}:
+		prefix = make([]byte, req.logPayloadMaxBytes)
+		n, err := v.ReadAt(prefix, v.Size()-int64(v.Len()))
+		if err != nil && err != io.EOF {
+			return err
+		}
+		prefix = prefix[:n]
+
+	default:
+		br := bufio.NewReaderSize(req.payload, req.logPayloadMaxBytes)
+		peeked, err := br.Peek(req.logPayloadMaxBytes)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		prefix = peeked
+		req.payload = br
+	}
+
+	if len(prefix) > req.logPayloadMaxBytes {
+		prefix = prefix[:req.logPayloadMaxBytes]
+	}
+	req.loggedPayload = make([]byte, len(prefix))
+	copy(req.loggedPayload, prefix)
+	return nil
+}
+
+// logPayload writes the payload captured by capturePayloadPrefix to the debug log
+func (req *Request) logPayload() {
+	if req.logPayloadMaxBytes <= 0 {
+		return
+	}
+	if req.sensitivePayload {
+		req.debugf("request payload: %s", redacted)
+		return
+	}
+	if req.optStreamingPayload {
+		req.debugf("request payload: streamed, so not logged")
+		return
+	}
+	req.debugf("request payload (first %d bytes): '%s'", len(req.loggedPayload), req.loggedPayload)
+}
+
+// WithSlowResponseThreshold logs an error when the Response takes longer than threshold to arrive, including all retries
+func WithSlowResponseThreshold(threshold time.Duration) RequestOption {
+	return func(c context.Context, req *Request) error {
+		req.slowResponseThreshold = threshold
+		return nil
+	}
+}
+
+// WithLargeResponseThreshold logs an error when the Response body is larger than threshold bytes
+// The Content-Length header is checked in Do, bodies without one are checked once read by Bytes
+func WithLargeResponseThreshold(threshold int64) RequestOption {
+	return func(c context.Context, req *Request) error {
+		req.largeResponseThreshold = threshold
+		return nil
+	}
+}
+
+// checkSlowResponse logs an error if dur exceeds the slow response threshold
+func (req *Request) checkSlowResponse(dur time.Duration) {
+	if req.slowResponseThreshold > 0 && dur > req.slowResponseThreshold {
+		req.errorf("slow response: took %s, threshold %s | req: %s", dur, req.slowResponseThreshold, req.String())
+	}
+}
+
+// checkLargeResponse logs an error if size exceeds the large response threshold
+func (req *Request) checkLargeResponse(size int64) {
+	if req.largeResponseThreshold > 0 && size > req.largeResponseThreshold {
+		req.errorf("large response: %d bytes, threshold %d bytes | req: %s", size, req.largeResponseThreshold, req.String())
+	}
+}
+
+// dumpRequest passes the wire representation of httpReq, with sensitive headers redacted, to the wireDumpFunc
+// httputil.DumpRequestOut replaces the body of the dumped copy, which is handed back to httpReq so it can still be sent
+func (req *Request) dumpRequest(httpReq *http.Request) {
+	if req.wireDumpFunc == nil {
+		return
+	}
+	dumpReq := *httpReq
+	dumpReq.Header = req.redactHTTPHeader(httpReq.Header)
+	dump, err := httputil.DumpRequestOut(&dumpReq, true)
+	httpReq.Body = dumpReq.Body
+	if err != nil {
+		req.errorf("httputil.DumpRequestOut failed: %s", err.Error())
+		return
+	}
+	req.wireDumpFunc(dump, true)
+}
+
+// dumpResponse passes the wire representation of httpResp, with sensitive headers redacted, to the wireDumpFunc
+// httputil.DumpResponse replaces the body of the dumped copy, which is handed back to httpResp so it can still be read
+func (req *Request) dumpResponse(httpResp *http.Response) {
+	if req.wireDumpFunc == nil || httpResp == nil {
+		return
+	}
+	dumpResp := *httpResp
+	dumpResp.Header = req.redactHTTPHeader(httpResp.Header)
+	dump, err := httputil.DumpResponse(&dumpResp, true)
+	httpResp.Body = dumpResp.Body
+	if err != nil {
+		req.errorf("httputil.DumpResponse failed: %s", err.Error())
+		return
+	}
+	req.wireDumpFunc(dump, false)
+}
+
 func (req *Request) debugf(format string, a ...interface{}) {
 	if req.debugLogFunc != nil {
 		req.debugLogFunc(logf(format, a...))
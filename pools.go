@@ -2,23 +2,57 @@ package fetcher
 
 import (
 	"bytes"
+	"context"
 	"sync"
+	"sync/atomic"
 )
 
 var bufferPool = &sync.Pool{
 	New: func() interface{} {
+		if atomic.LoadInt32(&poolInstrumentation) == 1 {
+			atomic.AddInt64(&poolNews, 1)
+		}
 		return &bytes.Buffer{}
 	},
 }
 
+// buffer pool instrumentation, enabled through WithPoolInstrumentation
+var (
+	poolInstrumentation int32
+	poolGets            int64
+	poolPuts            int64
+	poolNews            int64
+)
+
+// WithPoolInstrumentation is a ClientOption that enables the buffer pool counters returned by PoolStats
+// NOTE: the buffer pool is shared by all Clients, so instrumentation is enabled package-wide
+func WithPoolInstrumentation() ClientOption {
+	return func(c context.Context, cl *Client) error {
+		atomic.StoreInt32(&poolInstrumentation, 1)
+		return nil
+	}
+}
+
+// PoolStats returns the number of buffers taken from the pool, returned to the pool,
+// and newly allocated by the pool since instrumentation was enabled
+func PoolStats() (gets, puts, news int64) {
+	return atomic.LoadInt64(&poolGets), atomic.LoadInt64(&poolPuts), atomic.LoadInt64(&poolNews)
+}
+
 // getBuffer returns a buffer from the pool
 func getBuffer() (buf *bytes.Buffer) {
+	if atomic.LoadInt32(&poolInstrumentation) == 1 {
+		atomic.AddInt64(&poolGets, 1)
+	}
 	return bufferPool.Get().(*bytes.Buffer)
 }
 
 // putBuffer returns a buffer to the pool
 // The buffer is reset before it is put back into circulation
 func putBuffer(buf *bytes.Buffer) {
+	if atomic.LoadInt32(&poolInstrumentation) == 1 {
+		atomic.AddInt64(&poolPuts, 1)
+	}
 	buf.Reset()
 	bufferPool.Put(buf)
 }
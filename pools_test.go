@@ -0,0 +1,203 @@
+package fetcher
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestPoolStats(t *testing.T) {
+	if _, err := NewClient(context.Background(), WithPoolInstrumentation()); err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	gets, puts, _ := PoolStats()
+
+	buf := getBuffer()
+	putBuffer(buf)
+
+	gotGets, gotPuts, _ := PoolStats()
+	if gotGets-gets != 1 {
+		t.Errorf("gets = %d, want 1", gotGets-gets)
+	}
+	if gotPuts-puts != 1 {
+		t.Errorf("puts = %d, want 1", gotPuts-puts)
+	}
+}
+
+func TestPoolBalanced(t *testing.T) {
+	tests := []struct {
+		name           string
+		requestOptions []RequestOption
+		useResponse    func(c context.Context, resp *Response) error
+	}{
+		{
+			"JSON payload with Decode",
+			[]RequestOption{WithJSONPayload(testObject{URL: "https://nozzle.io/", Count: 30})},
+			func(c context.Context, resp *Response) error {
+				return resp.Decode(c, &testObject{})
+			},
+		},
+		{
+			"Gob payload with Bytes",
+			[]RequestOption{WithGobPayload(testObject{URL: "https://nozzle.io/", Count: 30})},
+			func(c context.Context, resp *Response) error {
+				_, err := resp.Bytes()
+				return err
+			},
+		},
+		{
+			"gzip payload with WithCopiedBody",
+			[]RequestOption{WithJSONPayload(testObject{URL: "https://nozzle.io/", Count: 30}), WithGzipPayload()},
+			func(c context.Context, resp *Response) error {
+				return resp.Decode(c, &testObject{}, WithCopiedBody())
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := context.Background()
+			ts := testServerHelper(t, &serverData{
+				headers:    map[string]string{ContentTypeHeader: ContentTypeJSON},
+				body:       []byte(`{"URL":"https://nozzle.io/","Count":30}`),
+				statusCode: 200,
+			})
+			defer ts.Close()
+
+			cl, err := NewClient(c, WithPoolInstrumentation())
+			if err != nil {
+				t.Fatalf("NewClient failed: %v", err)
+			}
+
+			gets, puts, _ := PoolStats()
+
+			resp, err := cl.Post(c, ts.URL, tt.requestOptions...)
+			if err != nil {
+				t.Fatalf("cl.Post failed: %v", err)
+			}
+			if err = tt.useResponse(c, resp); err != nil {
+				t.Fatalf("using response failed: %v", err)
+			}
+			resp.Close()
+			resp.Close()
+
+			gotGets, gotPuts, _ := PoolStats()
+			if gotGets-gets != gotPuts-puts {
+				t.Errorf("gets = %d, puts = %d, want balanced", gotGets-gets, gotPuts-puts)
+			}
+		})
+	}
+
+	t.Run("caller owned payload buffer is not pooled", func(t *testing.T) {
+		c := context.Background()
+		ts := testServerHelper(t, &serverData{statusCode: http.StatusOK})
+		defer ts.Close()
+
+		cl, err := NewClient(c, WithPoolInstrumentation())
+		if err != nil {
+			t.Fatalf("NewClient failed: %v", err)
+		}
+
+		_, puts, _ := PoolStats()
+		payload := getBuffer()
+		payload.WriteString("caller owned")
+		resp, err := cl.Post(c, ts.URL, WithReaderPayload(payload))
+		if err != nil {
+			t.Fatalf("cl.Post failed: %v", err)
+		}
+		resp.Close()
+
+		if _, gotPuts, _ := PoolStats(); gotPuts != puts {
+			t.Errorf("puts = %d, want 0", gotPuts-puts)
+		}
+	})
+}
+
+func TestPoolBalancedOnRequestError(t *testing.T) {
+	tests := []struct {
+		name           string
+		url            string
+		requestOptions []RequestOption
+	}{
+		{
+			"JSON encode error",
+			"http://mywebsite.com",
+			[]RequestOption{WithJSONPayload(make(chan int))},
+		},
+		{
+			"Gob encode error",
+			"http://mywebsite.com",
+			[]RequestOption{WithGobPayload(make(chan int))},
+		},
+		{
+			"option error after payload",
+			"http://mywebsite.com",
+			[]RequestOption{
+				WithJSONPayload(testObject{URL: "https://nozzle.io/", Count: 30}),
+				func(c context.Context, req *Request) error { return errors.New("test error") },
+			},
+		},
+		{
+			"invalid url after payload",
+			"http://my website.com/%zz",
+			[]RequestOption{WithURLEncodedPayload(url.Values{"a": []string{"1"}})},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := context.Background()
+			cl, err := NewClient(c, WithPoolInstrumentation())
+			if err != nil {
+				t.Fatalf("NewClient failed: %v", err)
+			}
+
+			gets, puts, _ := PoolStats()
+
+			if _, err = cl.NewRequest(c, http.MethodPost, tt.url, tt.requestOptions...); err == nil {
+				t.Fatal("NewRequest() error = nil, want error")
+			}
+
+			gotGets, gotPuts, _ := PoolStats()
+			if gotGets-gets != gotPuts-puts {
+				t.Errorf("gets = %d, puts = %d, want balanced", gotGets-gets, gotPuts-puts)
+			}
+		})
+	}
+}
+
+func TestReusableBufferBypassesPool(t *testing.T) {
+	c := context.Background()
+	if _, err := NewClient(c, WithPoolInstrumentation()); err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	buf := &bytes.Buffer{}
+	for _, body := range []string{`{"URL": "https://example.com/1", "Count": 1}`, `{"URL": "https://example.com/2", "Count": 2}`} {
+		gets, puts, _ := PoolStats()
+
+		resp := NewResponse(c, &Request{}, &http.Response{ContentLength: -1, Body: ioutil.NopCloser(strings.NewReader(body))})
+		var obj testObject
+		if err := resp.Decode(c, &obj, WithReusableBuffer(buf), WithCopiedBody(), WithJSONBody()); err != nil {
+			t.Fatalf("Decode failed: %v", err)
+		}
+		bts, err := resp.Bytes()
+		if err != nil {
+			t.Fatalf("Bytes failed: %v", err)
+		}
+		if err = resp.Close(); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+
+		if string(bts) != body || buf.String() != body {
+			t.Errorf("Bytes() = %s, buf = %s, want %s", bts, buf.String(), body)
+		}
+		if gotGets, gotPuts, _ := PoolStats(); gotGets != gets || gotPuts != puts {
+			t.Errorf("pool gets, puts changed by %d, %d, want 0, 0", gotGets-gets, gotPuts-puts)
+		}
+	}
+}
@@ -2,35 +2,133 @@ package fetcher
 
 import (
 	"context"
+	"net/url"
+	"sync"
 	"time"
+
+	"golang.org/x/time/rate"
 )
 
+// rateLimit is a token bucket, refilled at one token per enforcedRate and holding up to burst tokens
+// The bucket starts full, so a client that's been idle can send a burst of requests up to its quota
 type rateLimit struct {
 	enforcedRate time.Duration
-	ticker       *time.Ticker
+	limiter      *rate.Limiter
+
+	// spread throttled requests +/- 33% of enforcedRate around their slot, so clients don't send in lockstep
+	useJitter bool
 }
 
-func newRateLimit(rate int, dur time.Duration) rateLimit {
-	if rate <= 0 || dur <= 0 {
+func newRateLimit(requests int, dur time.Duration, burst int, useJitter bool) rateLimit {
+	if requests <= 0 || dur <= 0 {
 		return rateLimit{}
 	}
+	if burst < 1 {
+		burst = 1
+	}
+	enforcedRate := dur / time.Duration(requests)
 	return rateLimit{
-		enforcedRate: dur / time.Duration(rate),
-		ticker:       time.NewTicker(dur / time.Duration(rate)),
+		enforcedRate: enforcedRate,
+		limiter:      rate.NewLimiter(rate.Every(enforcedRate), burst),
+		useJitter:    useJitter,
 	}
 }
 
-func (rl *rateLimit) limit(c context.Context) {
+// limit waits for the rate limit, returning how long it waited
+// and the context error if c was done before the wait was over
+func (rl *rateLimit) limit(c context.Context) (time.Duration, error) {
 	if rl.enforcedRate == 0 {
-		return
+		return 0, nil
+	}
+
+	start := time.Now()
+	if !rl.useJitter {
+		if err := rl.limiter.Wait(c); err != nil {
+			// Wait fails early if the deadline would pass first, which is reported as the deadline being exceeded
+			if c.Err() == nil {
+				err = context.DeadlineExceeded
+			}
+			return time.Since(start), err
+		}
+		return time.Since(start), nil
+	}
+
+	// the token stays reserved for its slot, so jittering the wait moves the request without changing the rate
+	reservation := rl.limiter.Reserve()
+	delay := rl.jitterDelay(reservation.Delay())
+	if deadline, ok := c.Deadline(); ok && time.Until(deadline) < delay {
+		reservation.Cancel()
+		return time.Since(start), context.DeadlineExceeded
 	}
 
-	// wait for the ticker or c.Done
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
 	select {
-	case <-rl.ticker.C:
-		return
+	case <-timer.C:
+		return time.Since(start), nil
 	case <-c.Done():
-		rl.ticker.Stop()
-		return
+		reservation.Cancel()
+		return time.Since(start), c.Err()
+	}
+}
+
+// jitterDelay moves delay +/- 33% of enforcedRate, leaving requests within the burst (no delay) alone
+func (rl *rateLimit) jitterDelay(delay time.Duration) time.Duration {
+	if delay <= 0 {
+		return 0
+	}
+	delay += jitter(rl.enforcedRate) - rl.enforcedRate
+	if delay < 0 {
+		return 0
+	}
+	return delay
+}
+
+// RateLimitSpec limits requests to Rate per Per, allowing bursts of up to Burst requests,
+// with Jitter spreading throttled requests around their slot (see WithRateLimit)
+type RateLimitSpec struct {
+	Rate   int
+	Per    time.Duration
+	Burst  int
+	Jitter bool
+}
+
+// hostRateLimits holds a rateLimit per host, each created on first use from its RateLimitSpec
+type hostRateLimits struct {
+	specs map[string]RateLimitSpec
+
+	mu     sync.Mutex
+	limits map[string]*rateLimit
+}
+
+func newHostRateLimits(specs map[string]RateLimitSpec) *hostRateLimits {
+	hrl := &hostRateLimits{
+		specs:  make(map[string]RateLimitSpec, len(specs)),
+		limits: map[string]*rateLimit{},
+	}
+	for host, spec := range specs {
+		hrl.specs[host] = spec
+	}
+	return hrl
+}
+
+// get returns the rateLimit for the host of u, matching the host with its port before the bare hostname
+func (hrl *hostRateLimits) get(u *url.URL) (*rateLimit, bool) {
+	for _, host := range []string{u.Host, u.Hostname()} {
+		spec, ok := hrl.specs[host]
+		if !ok {
+			continue
+		}
+
+		hrl.mu.Lock()
+		defer hrl.mu.Unlock()
+		rl, ok := hrl.limits[host]
+		if !ok {
+			limit := newRateLimit(spec.Rate, spec.Per, spec.Burst, spec.Jitter)
+			rl = &limit
+			hrl.limits[host] = rl
+		}
+		return rl, true
 	}
+	return nil, false
 }
@@ -2,6 +2,8 @@ package fetcher
 
 import (
 	"context"
+	"net/url"
+	"sync"
 	"testing"
 	"time"
 )
@@ -10,49 +12,87 @@ func Test_rateLimit_limit(t *testing.T) {
 	type args struct {
 		rate        int
 		duration    time.Duration
+		burst       int
 		ctxDeadline time.Duration
 		runCount    int
+		jitter      bool
 	}
 	tests := []struct {
-		name string
-		args args
-		want *rateLimit
+		name    string
+		args    args
+		want    *rateLimit
+		wantErr bool
 	}{
 		{
-			"1 per second",
+			"1 per millisecond",
 			args{
 				rate:        5,
 				duration:    5 * time.Millisecond,
-				ctxDeadline: 5 * time.Millisecond,
-				runCount:    3,
+				burst:       1,
+				ctxDeadline: 50 * time.Millisecond,
+				runCount:    4,
 			},
 			&rateLimit{
 				enforcedRate: time.Millisecond,
 			},
+			false,
 		},
 		{
-			"10 per second",
+			"burst consumed without waiting",
 			args{
-				rate:        10,
-				duration:    1 * time.Millisecond,
-				ctxDeadline: 3 * time.Millisecond,
-				runCount:    20,
+				rate:        1,
+				duration:    10 * time.Millisecond,
+				burst:       5,
+				ctxDeadline: 100 * time.Millisecond,
+				runCount:    5,
 			},
 			&rateLimit{
-				enforcedRate: time.Millisecond / 10,
+				enforcedRate: 10 * time.Millisecond,
 			},
+			false,
 		},
 		{
-			"10 per second - killed by context",
+			"burst consumed then spaced",
 			args{
-				rate:        10,
-				duration:    1 * time.Millisecond,
-				ctxDeadline: 2 * time.Millisecond,
-				runCount:    30,
+				rate:        1,
+				duration:    10 * time.Millisecond,
+				burst:       3,
+				ctxDeadline: 100 * time.Millisecond,
+				runCount:    5,
 			},
 			&rateLimit{
-				enforcedRate: time.Millisecond / 10,
+				enforcedRate: 10 * time.Millisecond,
 			},
+			false,
+		},
+		{
+			"killed by context",
+			args{
+				rate:        1,
+				duration:    10 * time.Millisecond,
+				burst:       1,
+				ctxDeadline: 25 * time.Millisecond,
+				runCount:    10,
+			},
+			&rateLimit{
+				enforcedRate: 10 * time.Millisecond,
+			},
+			true,
+		},
+		{
+			"jitter with a rate too fast to jitter",
+			args{
+				rate:        1e9,
+				duration:    time.Second,
+				burst:       1,
+				ctxDeadline: 50 * time.Millisecond,
+				runCount:    5,
+				jitter:      true,
+			},
+			&rateLimit{
+				enforcedRate: time.Nanosecond,
+			},
+			false,
 		},
 		{
 			"no rate limit",
@@ -65,33 +105,165 @@ func Test_rateLimit_limit(t *testing.T) {
 			&rateLimit{
 				enforcedRate: 0,
 			},
+			false,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			rl := newRateLimit(tt.args.rate, tt.args.duration)
+			rl := newRateLimit(tt.args.rate, tt.args.duration, tt.args.burst, tt.args.jitter)
 
-			timeToProcess := rl.enforcedRate * time.Duration(tt.args.runCount)
-			finishAtOrAfter := time.Now().UTC().Add(timeToProcess)
-			if timeToProcess >= tt.args.ctxDeadline {
-				finishAtOrAfter = time.Now().UTC().Add(tt.args.ctxDeadline)
+			if tt.want.enforcedRate != rl.enforcedRate {
+				t.Errorf("rateLimit = %s, want %s", rl.enforcedRate.String(), tt.want.enforcedRate.String())
 			}
 
-			c, cancelFunc := context.WithDeadline(context.Background(), time.Now().UTC().Add(tt.args.ctxDeadline))
+			c, cancelFunc := context.WithTimeout(context.Background(), tt.args.ctxDeadline)
 			defer cancelFunc()
 
-			for i := 0; i < tt.args.runCount; i++ {
-				rl.limit(c)
+			start := time.Now()
+			var waited time.Duration
+			var err error
+			for i := 0; i < tt.args.runCount && err == nil; i++ {
+				var w time.Duration
+				w, err = rl.limit(c)
+				waited += w
 			}
+			elapsed := time.Since(start)
 
-			if tt.want.enforcedRate != rl.enforcedRate {
-				t.Errorf("rateLimit = %s, want %s", rl.enforcedRate.String(), tt.want.enforcedRate.String())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("limit() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if err != context.DeadlineExceeded {
+					t.Errorf("limit() error = %v, want %v", err, context.DeadlineExceeded)
+				}
+				return
 			}
 
-			tm := time.Now().UTC()
-			if !(tm.After(finishAtOrAfter) || tm.Equal(finishAtOrAfter)) {
-				t.Errorf("time = %s, want finishAtOrAfter %s", tm.Format("2006-01-02 15:04:05.9999"), finishAtOrAfter.Format("2006-01-02 15:04:05.9999"))
+			// the first burst requests are free, the rest wait for a token each
+			var wantWait time.Duration
+			if unburst := tt.args.runCount - tt.args.burst; rl.enforcedRate > 0 && unburst > 0 {
+				wantWait = rl.enforcedRate * time.Duration(unburst)
+			}
+			if waited < wantWait-time.Millisecond || waited > elapsed {
+				t.Errorf("waited = %s over %s, want at least %s", waited, elapsed, wantWait)
+			}
+			if wantWait == 0 && waited > 5*time.Millisecond {
+				t.Errorf("waited = %s, want no wait within the burst", waited)
 			}
 		})
 	}
 }
+
+func TestPerHostRateLimit(t *testing.T) {
+	c := context.Background()
+	cl, err := NewClient(c,
+		WithRateLimit(1, time.Second, 1, false),
+		WithPerHostRateLimit(map[string]RateLimitSpec{
+			"api.example.com":      {Rate: 10, Per: time.Second, Burst: 2},
+			"api.example.com:8443": {Rate: 5, Per: time.Second, Burst: 1},
+		}),
+	)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	tests := []struct {
+		url         string
+		wantDefault bool
+		wantRate    time.Duration
+	}{
+		{"https://api.example.com/items", false, 100 * time.Millisecond},
+		{"http://api.example.com:8080/items", false, 100 * time.Millisecond},
+		{"https://api.example.com:8443/items", false, 200 * time.Millisecond},
+		{"https://other.example.com/items", true, time.Second},
+	}
+	for _, tt := range tests {
+		u, _ := url.Parse(tt.url)
+		rl := cl.rateLimitFor(u)
+		if (rl == &cl.rateLimit) != tt.wantDefault || rl.enforcedRate != tt.wantRate {
+			t.Errorf("%s: rate limit %s (default %v), want %s (default %v)", tt.url, rl.enforcedRate, rl == &cl.rateLimit, tt.wantRate, tt.wantDefault)
+		}
+	}
+
+	// the limiter for a host is created once and shared, including between goroutines
+	u, _ := url.Parse("https://api.example.com/items")
+	limits := make(chan *rateLimit, 10)
+	var wg sync.WaitGroup
+	for i := 0; i < cap(limits); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			limits <- cl.rateLimitFor(u)
+		}()
+	}
+	wg.Wait()
+	close(limits)
+	first := <-limits
+	for rl := range limits {
+		if rl != first {
+			t.Fatalf("got different limiters for the same host")
+		}
+	}
+
+	// the burst of 2 is shared by every request to the host
+	for i := 0; i < 2; i++ {
+		if waited, _ := first.limit(c); waited > 5*time.Millisecond {
+			t.Errorf("request %d within the burst waited %s", i+1, waited)
+		}
+	}
+	if waited, _ := first.limit(c); waited < 50*time.Millisecond {
+		t.Errorf("request after the burst waited %s, want about 100ms", waited)
+	}
+}
+
+func TestRateLimitJitter(t *testing.T) {
+	rl := newRateLimit(1, 30*time.Millisecond, 1, true)
+
+	// the offsets stay within a third of the spacing either side, and average out
+	const samples = 10000
+	var sum time.Duration
+	min, max := time.Hour, time.Duration(0)
+	for i := 0; i < samples; i++ {
+		delay := rl.jitterDelay(time.Second)
+		sum += delay
+		if delay < min {
+			min = delay
+		}
+		if delay > max {
+			max = delay
+		}
+	}
+	if spread := rl.enforcedRate / 3; min < time.Second-spread || max > time.Second+spread {
+		t.Errorf("jittered delays in [%s, %s], want within %s of 1s", min, max, spread)
+	}
+	if max-min < rl.enforcedRate/2 {
+		t.Errorf("jittered delays in [%s, %s], want them spread out", min, max)
+	}
+	if mean := sum / samples; mean < time.Second-time.Millisecond || mean > time.Second+time.Millisecond {
+		t.Errorf("mean jittered delay = %s, want about 1s", mean)
+	}
+	if delay := rl.jitterDelay(0); delay != 0 {
+		t.Errorf("jittered delay within the burst = %s, want 0", delay)
+	}
+	// a third of a spacing under 3ns rounds down to nothing to jitter by
+	fast := newRateLimit(1e9, time.Second, 1, true)
+	if delay := fast.jitterDelay(time.Millisecond); delay != time.Millisecond {
+		t.Errorf("jittered delay with a 1ns spacing = %s, want 1ms unchanged", delay)
+	}
+
+	// the reservations keep their slots, so the mean rate over many requests is unchanged
+	rl = newRateLimit(1, 5*time.Millisecond, 1, true)
+	c := context.Background()
+	const requests = 40
+	start := time.Now()
+	for i := 0; i < requests; i++ {
+		if _, err := rl.limit(c); err != nil {
+			t.Fatalf("limit() error = %v", err)
+		}
+	}
+	// the first request is within the burst
+	want := rl.enforcedRate * (requests - 1)
+	if elapsed := time.Since(start); elapsed < want-2*rl.enforcedRate || elapsed > want+want/4 {
+		t.Errorf("%d jittered requests took %s, want about %s", requests, elapsed, want)
+	}
+}
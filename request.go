@@ -3,17 +3,23 @@ package fetcher
 import (
 	"bytes"
 	"context"
-	"encoding/gob"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"mime/multipart"
+	"net"
 	"net/http"
 	"net/http/httptrace"
+	"net/textproto"
 	"net/url"
 	"os"
+	"path"
+	"sort"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 )
 
@@ -27,6 +33,15 @@ const (
 	// ContentTypeXML = "application/xml"
 	ContentTypeXML = "application/xml"
 
+	// ContentTypeCSV = "text/csv"
+	ContentTypeCSV = "text/csv"
+
+	// ContentTypeJSONMergePatch = "application/merge-patch+json"
+	ContentTypeJSONMergePatch = "application/merge-patch+json"
+
+	// ContentTypeJSONPatch = "application/json-patch+json"
+	ContentTypeJSONPatch = "application/json-patch+json"
+
 	// ContentTypeURLEncoded = "application/x-www-form-urlencoded"
 	ContentTypeURLEncoded = "application/x-www-form-urlencoded"
 
@@ -35,6 +50,18 @@ const (
 
 	// AcceptHeader = "Accept"
 	AcceptHeader = "Accept"
+
+	// IfMatchHeader = "If-Match"
+	IfMatchHeader = "If-Match"
+
+	// ETagHeader = "ETag"
+	ETagHeader = "ETag"
+
+	// AuthorizationHeader = "Authorization"
+	AuthorizationHeader = "Authorization"
+
+	// AcceptLanguageHeader = "Accept-Language"
+	AcceptLanguageHeader = "Accept-Language"
 )
 
 // Request contains the data for a http.Request to be created
@@ -42,6 +69,9 @@ type Request struct {
 	client  *Client
 	request *http.Request
 
+	// the url given to NewRequest, before any option changes it
+	originalURL string
+
 	// set through options
 	method  string
 	url     string
@@ -50,14 +80,51 @@ type Request struct {
 	headers []header
 	cookies []*http.Cookie
 
+	// overrides the Host header sent, which defaults to the url host
+	host string
+
+	// joined with the url before the request is built
+	baseURL string
+
+	// replaces the url fragment
+	fragment string
+
+	// clean the url path before the request is built
+	optNormalizeURL bool
+
+	// encode the params in the order they were added, rather than sorted by key
+	optUnsortedQuery bool
+
+	// send the payload with chunked Transfer-Encoding, even when its length is known
+	optChunked bool
+
 	// BasicAuth options
 	optBasicAuth bool
 	username     string
 	password     string
 
+	// pooled buffer backing the payload, returned to the pool once the request is done
+	payloadBuffer *bytes.Buffer
+
+	// pipe a goroutine writes the payload to, closed if the Request can't be created so the goroutine ends
+	payloadPipe *io.PipeReader
+
+	// the payload is streamed by WithStreamingJSONPayload, so it can't be buffered to compress or checksum it
+	optStreamingPayload bool
+
+	// compress the payload with the registered Compressor for this Content-Encoding
+	payloadEncoding string
+
+	// headers set to a hash of the payload as sent
+	checksums []checksum
+
+	// decompress the Response body based on its Content-Encoding
+	optDecompress bool
+
 	// multipart form details
 	optMultiPartForm         bool
 	multiPartFormFieldParams []param
+	multipartParts           []multipartPart
 	multiPartFormErr         error
 
 	// append using WithAfterDoFunc option
@@ -68,21 +135,70 @@ type Request struct {
 	clientTrace *httptrace.ClientTrace
 
 	// retry config
-	maxAttempts     int
-	backoffStrategy backoffStrategy
-	retryOnEOFError bool
+	maxAttempts            int
+	backoffStrategy        backoffStrategy
+	retryOnEOFError        bool
+	retryOnConnectionReset bool
+	attemptTimeout         time.Duration
+
+	// response headers that cause a retry whatever the status code, see WithRetryOnHeader
+	retryOnHeaders []header
+
+	// header set to the remaining time budget in milliseconds on each attempt
+	deadlineHeader string
+
+	// send up to maxHedged duplicates of an attempt, each after hedgeAfter without a response
+	hedgeAfter time.Duration
+	maxHedged  int
+
+	// header set to a new nonce from nonceFunc on each attempt
+	nonceHeader string
+	nonceFunc   func() string
+
+	// called once on a 401 response before the request is resent
+	refreshOn401 func(c context.Context) error
+
+	// name of the transport registered with WithClientTransports to send the request with
+	transportName string
+
+	// attempts made and the last status code received, reported by RequestError
+	attempts   int
+	lastStatus int
+
+	// every request sent, in order, see Response.AttemptHistory
+	attemptHistory []AttemptRecord
+
+	// total time spent waiting on the client rate limit, across all attempts
+	throttled    time.Duration
+	fallbackURLs []string
+	onRetryFunc  func(attempt int, resp *Response, err error, nextDelay time.Duration)
 
 	errorLogFunc LogFunc
 	debugLogFunc LogFunc
+	wireDumpFunc WireDumpFunc
+
+	// header names redacted in String() and logs, in addition to defaultRedactedHeaders
+	redactHeaders []string
+
+	// response thresholds that trigger an error log when exceeded
+	slowResponseThreshold  time.Duration
+	largeResponseThreshold int64
+
+	// payload logging
+	logPayloadMaxBytes int
+	loggedPayload      []byte
+	sensitivePayload   bool
 }
 
 // NewRequest returns a new Request with the given method/url and options executed
 func (cl *Client) NewRequest(c context.Context, method, urlStr string, opts ...RequestOption) (*Request, error) {
 	req := &Request{
-		method:          method,
-		url:             urlStr,
-		maxAttempts:     1,
-		backoffStrategy: defaultBackoffStrategy,
+		originalURL:            urlStr,
+		method:                 method,
+		url:                    urlStr,
+		maxAttempts:            1,
+		backoffStrategy:        defaultBackoffStrategy,
+		retryOnConnectionReset: true,
 	}
 	var err error
 
@@ -90,8 +206,52 @@ func (cl *Client) NewRequest(c context.Context, method, urlStr string, opts ...R
 	opts = append(cl.parentRequestOptions, opts...)
 
 	// execute all options
+	// any pooled payload buffer is returned to the pool, and any payload pipe closed, if the Request can't be created
 	for _, opt := range opts {
 		if err = opt(c, req); err != nil {
+			req.abandonPayload()
+			return nil, err
+		}
+	}
+
+	// the multipart payload is built once all its fields and parts have been added
+	if len(req.multipartParts) > 0 {
+		req.multipartPayload(c)
+	}
+
+	// a streamed payload would have to be buffered to compress or checksum it
+	if req.optStreamingPayload && (req.payloadEncoding != "" || len(req.checksums) > 0) {
+		req.abandonPayload()
+		return nil, errors.New("a streaming payload can't be compressed or checksummed")
+	}
+
+	// capture the payload prefix before it is compressed
+	if err = req.capturePayloadPrefix(); err != nil {
+		req.abandonPayload()
+		return nil, err
+	}
+
+	if err = req.compressPayload(); err != nil {
+		req.abandonPayload()
+		return nil, err
+	}
+
+	// checksum the payload after it is compressed, as that is what is sent
+	if err = req.setChecksumHeaders(); err != nil {
+		req.abandonPayload()
+		return nil, err
+	}
+
+	if req.baseURL != "" {
+		if req.url, err = joinBaseURL(req.baseURL, req.url); err != nil {
+			req.abandonPayload()
+			return nil, err
+		}
+	}
+
+	if req.optNormalizeURL {
+		if req.url, err = normalizeURL(req.url); err != nil {
+			req.abandonPayload()
 			return nil, err
 		}
 	}
@@ -99,6 +259,7 @@ func (cl *Client) NewRequest(c context.Context, method, urlStr string, opts ...R
 	// setDefaultRequestOptions(req)
 	req.request, err = http.NewRequest(req.method, req.url, req.payload)
 	if err != nil {
+		req.abandonPayload()
 		return nil, err
 	}
 
@@ -107,13 +268,31 @@ func (cl *Client) NewRequest(c context.Context, method, urlStr string, opts ...R
 		req.request.Header.Add(req.headers[i].key, req.headers[i].value)
 	}
 
-	// add the params and write to the URL
+	// fall back to the client default Accept header, unless the request set one
+	if cl.defaultAccept != "" && req.request.Header.Get(AcceptHeader) == "" {
+		req.request.Header.Set(AcceptHeader, cl.defaultAccept)
+	}
+
+	// add the params to any query already in the URL, and write them back
 	if len(req.params) > 0 {
-		params := url.Values{}
-		for i := range req.params {
-			params.Add(req.params[i].key, req.params[i].value)
-		}
-		req.request.URL.RawQuery = params.Encode()
+		req.addParams(req.request.URL)
+		req.url = req.request.URL.String()
+	}
+
+	// force chunked encoding, which http.Request only uses for payloads of unknown length by default
+	if req.optChunked && req.payload != nil {
+		req.request.TransferEncoding = []string{"chunked"}
+		req.request.ContentLength = 0
+	}
+
+	// override the Host header, which http.Request takes from its Host field rather than the header map
+	if req.host != "" {
+		req.request.Host = req.host
+	}
+
+	// set the fragment, which is kept in the url but never sent to the server
+	if req.fragment != "" {
+		req.request.URL.Fragment = req.fragment
 		req.url = req.request.URL.String()
 	}
 
@@ -132,6 +311,39 @@ func (cl *Client) NewRequest(c context.Context, method, urlStr string, opts ...R
 	return req, nil
 }
 
+// NewRequestFromHTTP returns a new Request copying the method, url, headers and body of r, e.g. to forward a request received by a handler
+// Hop-by-hop headers aren't copied, and the opts are executed after r is copied so they can override it
+// The url of a request received by a server is relative, so use WithBaseURL to set the upstream it's forwarded to
+func (cl *Client) NewRequestFromHTTP(c context.Context, r *http.Request, opts ...RequestOption) (*Request, error) {
+	fromHTTP := func(c context.Context, req *Request) error {
+		skip := hopByHopHeaderSet(r.Header)
+		skip["Content-Length"] = true
+		for key, values := range r.Header {
+			if skip[key] {
+				continue
+			}
+			for _, value := range values {
+				req.headers = append(req.headers, newHeader(key, value))
+			}
+		}
+		if r.Body != nil && r.Body != http.NoBody {
+			req.payload = r.Body
+		}
+		return nil
+	}
+
+	req, err := cl.NewRequest(c, r.Method, r.URL.String(), append([]RequestOption{fromHTTP}, opts...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	// keep the length of a body that is forwarded as-is, rather than sending it chunked
+	if req.payload == r.Body && r.ContentLength > 0 && !req.optChunked {
+		req.request.ContentLength = r.ContentLength
+	}
+	return req, nil
+}
+
 // String is a stringer for Request
 func (req Request) String() string {
 	var payload []byte
@@ -139,28 +351,66 @@ func (req Request) String() string {
 	case *bytes.Buffer:
 		payload = v.Bytes()
 	}
+	if req.sensitivePayload {
+		payload = []byte(redacted)
+	}
 	return fmt.Sprintf("method:%s | url:%s | maxAttempts:%d | headers:%s | payload (string):'%s'",
 		req.method,
 		req.url,
 		req.maxAttempts,
-		req.headers,
+		req.redactedHeaders(),
 		string(payload),
 	)
 }
 
+// Method returns the HTTP method of the Request
+func (req *Request) Method() string {
+	return req.method
+}
+
+// URL returns the url of the Request, including any params
+func (req *Request) URL() string {
+	return req.url
+}
+
+// MaxAttempts returns the maximum number of attempts for the Request
+func (req *Request) MaxAttempts() int {
+	return req.maxAttempts
+}
+
+// Header returns a copy of the headers that will be sent with the Request
+func (req *Request) Header() http.Header {
+	return req.request.Header.Clone()
+}
+
+// PayloadBytes returns the payload without consuming it, so the Request can still be sent
+// used by fetchermock
+func (req *Request) PayloadBytes() ([]byte, error) {
+	if req.payload == nil {
+		return nil, nil
+	}
+	return req.payloadBytes()
+}
+
 // Equal compares the request with another request
 // If not equal, a string is returned with first field found different
 // used by fetchermock
 func (req *Request) Equal(reqComp *Request) (bool, string) {
+	if reqComp != nil && req.url != reqComp.url {
+		return false, fmt.Sprintf("url: %s != %s", req.url, reqComp.url)
+	}
+	return req.EqualIgnoringURL(reqComp)
+}
+
+// EqualIgnoringURL compares the request with another request like Equal, except for their urls
+// used by fetchermock, for expectations that match the url separately
+func (req *Request) EqualIgnoringURL(reqComp *Request) (bool, string) {
 	if reqComp == nil {
 		return false, "comparison Request is nil"
 	}
 	if req.method != reqComp.method {
 		return false, fmt.Sprintf("method: %s != %s", req.method, reqComp.method)
 	}
-	if req.url != reqComp.url {
-		return false, fmt.Sprintf("url: %s != %s", req.url, reqComp.url)
-	}
 	if req.maxAttempts != reqComp.maxAttempts {
 		return false, fmt.Sprintf("maxAttempts: %d != %d", req.maxAttempts, reqComp.maxAttempts)
 	}
@@ -171,28 +421,58 @@ func (req *Request) Equal(reqComp *Request) (bool, string) {
 	}
 
 	if req.payload != nil && reqComp.payload != nil {
-		reqBody, err := ioutil.ReadAll(req.payload)
+		reqBody, err := req.payloadBytes()
 		if err != nil {
 			return false, fmt.Sprintf("couldn't read body %s", err)
 		}
 
-		reqCompBody, err := ioutil.ReadAll(reqComp.payload)
+		reqCompBody, err := reqComp.payloadBytes()
 		if err != nil {
 			return false, fmt.Sprintf("couldn't read body %s", err)
 		}
 
-		if len(reqBody) != len(reqCompBody) {
+		if !bytes.Equal(reqBody, reqCompBody) {
 			return false, fmt.Sprintf("bodies don't match got %s expected %s", reqBody, reqCompBody)
 		}
+	}
 
-		for i := range reqBody {
-			if reqBody[i] != reqCompBody[i] {
-				return false, fmt.Sprintf("bodies don't match got %s expected %s", reqBody, reqCompBody)
-			}
+	return true, ""
+}
+
+// payloadBytes returns the payload without consuming it, so the Request can still be sent
+// Payloads that can't be replayed are buffered, and the buffered copy replaces the Request body
+func (req *Request) payloadBytes() ([]byte, error) {
+	if req.payload == nil {
+		return nil, nil
+	}
+
+	// http.NewRequest snapshots *bytes.Buffer, *bytes.Reader and *strings.Reader payloads
+	if req.request != nil && req.request.GetBody != nil {
+		body, err := req.request.GetBody()
+		if err != nil {
+			return nil, err
 		}
+		defer body.Close()
+		return ioutil.ReadAll(body)
 	}
 
-	return true, ""
+	if buf, ok := req.payload.(*bytes.Buffer); ok {
+		return buf.Bytes(), nil
+	}
+
+	payload, err := ioutil.ReadAll(req.payload)
+	if err != nil {
+		return nil, err
+	}
+	req.payload = bytes.NewReader(payload)
+	if req.request != nil {
+		req.request.Body = ioutil.NopCloser(bytes.NewReader(payload))
+		req.request.ContentLength = int64(len(payload))
+		req.request.GetBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(payload)), nil
+		}
+	}
+	return payload, nil
 }
 
 type header struct {
@@ -220,48 +500,187 @@ func newParam(key, value string) param {
 // RequestOption is a func to configure optional Request settings
 type RequestOption func(c context.Context, req *Request) error
 
-// WithBaseURL prepends the req.url with the given baseURL
+// WithBaseURL joins the req.url onto the given baseURL, regardless of trailing or leading slashes
+// An absolute req.url is used as-is, and the query strings of both are kept
+// The url is joined once all RequestOptions have run, so option order doesn't matter
 func WithBaseURL(baseURL string) RequestOption {
 	return func(c context.Context, req *Request) error {
-		req.url = baseURL + req.url
+		req.baseURL = baseURL
+		return nil
+	}
+}
+
+// joinBaseURL joins ref onto the path of baseURL, unless ref is an absolute url
+func joinBaseURL(baseURL, ref string) (string, error) {
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return "", err
+	}
+	refURL, err := url.Parse(ref)
+	if err != nil {
+		return "", err
+	}
+	if refURL.IsAbs() {
+		return ref, nil
+	}
+
+	// ResolveReference replaces the last base path segment and drops the base query, so join the paths and queries instead
+	if refURL.Path != "" {
+		if !strings.HasSuffix(base.Path, "/") {
+			base.Path += "/"
+			base.RawPath = ""
+		}
+		refURL.Path = strings.TrimPrefix(refURL.Path, "/")
+		refURL.RawPath = strings.TrimPrefix(refURL.RawPath, "/")
+	}
+	if base.RawQuery != "" && refURL.RawQuery != "" {
+		refURL.RawQuery = base.RawQuery + "&" + refURL.RawQuery
+	} else if refURL.RawQuery == "" {
+		refURL.RawQuery = base.RawQuery
+	}
+	return base.ResolveReference(refURL).String(), nil
+}
+
+// WithFragment sets the url fragment, replacing any fragment in the url
+func WithFragment(fragment string) RequestOption {
+	return func(c context.Context, req *Request) error {
+		req.fragment = fragment
 		return nil
 	}
 }
 
+// WithNormalizedURL cleans the url path before the request is built,
+// collapsing duplicate slashes and resolving . and .. elements (see path.Clean)
+// A trailing slash is kept, since services may treat it as a different resource
+func WithNormalizedURL() RequestOption {
+	return func(c context.Context, req *Request) error {
+		req.optNormalizeURL = true
+		return nil
+	}
+}
+
+// normalizeURL cleans the path of rawURL
+func normalizeURL(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	u.Path = cleanPath(u.Path)
+	u.RawPath = cleanPath(u.RawPath)
+	return u.String(), nil
+}
+
+// cleanPath runs path.Clean on a non-empty p, keeping any trailing slash
+func cleanPath(p string) string {
+	if p == "" {
+		return p
+	}
+	cleaned := path.Clean(p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned
+}
+
 // WithJSONPayload json marshals the payload for the Request
 // and sets the content-type and accept header to application/json
 func WithJSONPayload(payload interface{}) RequestOption {
+	return WithPayload(ContentTypeJSON, payload)
+}
+
+// WithJSONMergePatchPayload json marshals the payload for the Request as an RFC 7386 merge patch,
+// setting the content-type header to application/merge-patch+json and the accept header to application/json
+func WithJSONMergePatchPayload(payload interface{}) RequestOption {
 	return func(c context.Context, req *Request) error {
-		if payload == nil {
+		return req.jsonPayload(ContentTypeJSONMergePatch, payload)
+	}
+}
+
+// PatchOp is a single RFC 6902 JSON Patch operation
+// A nil Value is sent as null, except for the remove, move and copy ops, which have no value
+type PatchOp struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	From  string      `json:"from,omitempty"`
+	Value interface{} `json:"value"`
+}
+
+// MarshalJSON implements json.Marshaler, omitting the value member of the ops that have none
+func (op PatchOp) MarshalJSON() ([]byte, error) {
+	switch op.Op {
+	case "remove", "move", "copy":
+		return json.Marshal(struct {
+			Op   string `json:"op"`
+			Path string `json:"path"`
+			From string `json:"from,omitempty"`
+		}{op.Op, op.Path, op.From})
+	}
+	// the conversion drops the MarshalJSON method, so it isn't called recursively
+	type patchOp PatchOp
+	return json.Marshal(patchOp(op))
+}
+
+// WithJSONPatchPayload json marshals the ops for the Request as an RFC 6902 JSON Patch document,
+// setting the content-type header to application/json-patch+json and the accept header to application/json
+func WithJSONPatchPayload(ops []PatchOp) RequestOption {
+	return func(c context.Context, req *Request) error {
+		if ops == nil {
 			return nil
 		}
+		return req.jsonPayload(ContentTypeJSONPatch, ops)
+	}
+}
+
+// jsonPayload json marshals the payload into a pooled buffer,
+// setting the accept header to application/json and the content-type header to contentType
+func (req *Request) jsonPayload(contentType string, payload interface{}) error {
+	if payload == nil {
+		return nil
+	}
+	req.headers = append(req.headers, newHeader(AcceptHeader, ContentTypeJSON))
+	req.headers = append(req.headers, newHeader(ContentTypeHeader, contentType))
+	return req.encodePayload(ContentTypeJSON, payload)
+}
+
+// WithStreamingJSONPayload streams the values encoded by fn as the payload for the Request,
+// instead of buffering them, and sets the content-type and accept header to application/json
+// fn runs in a goroutine writing to a pipe that's read as the request is sent, and an error returned by fn fails the request
+// NOTE: each Encode call writes one newline-delimited value, and the payload can only be sent once, so it isn't retried
+// It can't be combined with WithCompressedPayload or the checksum options, which would have to buffer it
+func WithStreamingJSONPayload(fn func(enc *json.Encoder) error) RequestOption {
+	return func(c context.Context, req *Request) error {
+		pipeReader, pipeWriter := io.Pipe()
+		req.payload = pipeReader
+		req.payloadPipe = pipeReader
+		req.optStreamingPayload = true
 		req.headers = append(req.headers, newHeader(AcceptHeader, ContentTypeJSON))
 		req.headers = append(req.headers, newHeader(ContentTypeHeader, ContentTypeJSON))
-		buf := getBuffer()
-		if err := json.NewEncoder(buf).Encode(payload); err != nil {
-			return err
-		}
-		req.payload = buf
+
+		go copyStreamingJSONToPipeWriter(c, fn, pipeWriter)
 		return nil
 	}
 }
 
+// copyStreamingJSONToPipeWriter runs fn on the pipe, closing it with fn's error, or with the context error if c is done first
+// if the pipe reader is closed before fn is done, its writes fail and end it early
+func copyStreamingJSONToPipeWriter(c context.Context, fn func(enc *json.Encoder) error, pipeWriter *io.PipeWriter) {
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- fn(json.NewEncoder(pipeWriter))
+	}()
+
+	select {
+	case err := <-errChan:
+		pipeWriter.CloseWithError(err)
+	case <-c.Done():
+		pipeWriter.CloseWithError(c.Err())
+	}
+}
+
 // WithGobPayload gob encodes the payload for the Request
 // and sets the content-type and accept header to application/gob
 func WithGobPayload(payload interface{}) RequestOption {
-	return func(c context.Context, req *Request) error {
-		if payload == nil {
-			return nil
-		}
-		req.headers = append(req.headers, newHeader(AcceptHeader, ContentTypeGob))
-		req.headers = append(req.headers, newHeader(ContentTypeHeader, ContentTypeGob))
-		buf := getBuffer()
-		if err := gob.NewEncoder(buf).Encode(payload); err != nil {
-			return err
-		}
-		req.payload = buf
-		return nil
-	}
+	return WithPayload(ContentTypeGob, payload)
 }
 
 // WithURLEncodedPayload encodes the payload for the Request
@@ -271,14 +690,56 @@ func WithURLEncodedPayload(payload url.Values) RequestOption {
 		if payload == nil {
 			return nil
 		}
-		buf := getBuffer()
-		buf.WriteString(payload.Encode())
 		req.headers = append(req.headers, newHeader(ContentTypeHeader, ContentTypeURLEncoded))
-		req.payload = buf
-		return nil
+		return req.encodePayload(ContentTypeURLEncoded, payload)
 	}
 }
 
+// WithFormStruct sets the payload to the fields of the struct v (or a pointer to it) as form-urlencoded,
+// keyed by their `form` or `url` tags (or names) like WithFormBody decodes them
+// Slice fields add a value per element, and fields tagged omitempty are skipped when they're zero
+func WithFormStruct(v interface{}) RequestOption {
+	return func(c context.Context, req *Request) error {
+		values, err := encodeFormValues(v)
+		if err != nil {
+			return err
+		}
+		return WithURLEncodedPayload(values)(c, req)
+	}
+}
+
+// setPayloadBuffer sets the pooled buf as the payload,
+// releasing any previously pooled payload buffer back to the pool
+func (req *Request) setPayloadBuffer(buf *bytes.Buffer) {
+	req.releasePayloadBuffer()
+	req.payloadBuffer = buf
+	req.payload = buf
+}
+
+// abandonPayload releases the payload of a Request that can't be created,
+// returning its pooled buffer and closing its pipe so the goroutine writing to it ends
+func (req *Request) abandonPayload() {
+	req.releasePayloadBuffer()
+	req.closePayloadPipe()
+}
+
+// closePayloadPipe ends the goroutine writing a streaming payload, for a Request that won't be sent
+func (req *Request) closePayloadPipe() {
+	if req.payloadPipe != nil {
+		req.payloadPipe.CloseWithError(errors.New("request abandoned"))
+	}
+}
+
+// releasePayloadBuffer returns the pooled payload buffer to the pool, if there is one
+// It is safe to call more than once
+func (req *Request) releasePayloadBuffer() {
+	if req.payloadBuffer == nil {
+		return
+	}
+	putBuffer(req.payloadBuffer)
+	req.payloadBuffer = nil
+}
+
 // WithParam adds parameter value to be encoded for the Request
 func WithParam(key, value string) RequestOption {
 	return func(c context.Context, req *Request) error {
@@ -287,14 +748,63 @@ func WithParam(key, value string) RequestOption {
 	}
 }
 
-// WithBytesPayload sets the given payload for the Request
-func WithBytesPayload(payload []byte) RequestOption {
+// addParams adds the params to any query already in u, sorting them by key unless WithUnsortedQuery was given
+func (req *Request) addParams(u *url.URL) {
+	if req.optUnsortedQuery {
+		u.RawQuery = req.unsortedQuery(u.RawQuery)
+		return
+	}
+	params := u.Query()
+	for i := range req.params {
+		params.Add(req.params[i].key, req.params[i].value)
+	}
+	u.RawQuery = params.Encode()
+}
+
+// WithUnsortedQuery encodes the params in the order they were added, after any query already in the url,
+// rather than sorting them by key, e.g. for signature schemes that sign the params in their declared order
+func WithUnsortedQuery() RequestOption {
 	return func(c context.Context, req *Request) error {
-		req.payload = bytes.NewReader(payload)
+		req.optUnsortedQuery = true
 		return nil
 	}
 }
 
+// unsortedQuery appends the params to rawQuery in the order they were added
+func (req *Request) unsortedQuery(rawQuery string) string {
+	var sb strings.Builder
+	sb.WriteString(rawQuery)
+	for i := range req.params {
+		if sb.Len() > 0 {
+			sb.WriteByte('&')
+		}
+		sb.WriteString(url.QueryEscape(req.params[i].key))
+		sb.WriteByte('=')
+		sb.WriteString(url.QueryEscape(req.params[i].value))
+	}
+	return sb.String()
+}
+
+// WithParamInt adds the base 10 formatted int parameter value to be encoded for the Request
+func WithParamInt(key string, value int) RequestOption {
+	return WithParam(key, strconv.Itoa(value))
+}
+
+// WithParamBool adds the "true" or "false" formatted bool parameter value to be encoded for the Request
+func WithParamBool(key string, value bool) RequestOption {
+	return WithParam(key, strconv.FormatBool(value))
+}
+
+// WithParamTime adds the time parameter value, formatted with layout, to be encoded for the Request
+func WithParamTime(key string, value time.Time, layout string) RequestOption {
+	return WithParam(key, value.Format(layout))
+}
+
+// WithBytesPayload sets the given payload for the Request
+func WithBytesPayload(payload []byte) RequestOption {
+	return WithBody(payload, "")
+}
+
 // WithRetryOnEOFError adds the io.EOF error to the retry loop
 // The io.EOF error indicates sending on a broken connection (see https://github.com/golang/go/issues/8946 & https://github.com/golang/go/issues/5312)
 // Including this option with a Request will allow fetcher to retry the request on io.EOF, in attempt to obtain a valid connection
@@ -305,6 +815,81 @@ func WithRetryOnEOFError() RequestOption {
 	}
 }
 
+// WithRetryOnConnectionReset sets whether a connection reset by the server (ECONNRESET) is retried
+// Connection resets are retried by default, pass false to return them as breaking errors instead
+func WithRetryOnConnectionReset(retry bool) RequestOption {
+	return func(c context.Context, req *Request) error {
+		req.retryOnConnectionReset = retry
+		return nil
+	}
+}
+
+// WithRetryOnHeader retries the Request when the response has the header key set to value (compared case-insensitively),
+// whatever its status code, e.g. for an API returning 200 with X-Retry: true while a backend warms up
+// It can be given more than once, retrying on any of the headers, alongside the usual retries on 5xx status codes
+// The response of the last attempt is returned with its body unread, even if it still has the header
+func WithRetryOnHeader(key, value string) RequestOption {
+	return func(c context.Context, req *Request) error {
+		req.retryOnHeaders = append(req.retryOnHeaders, newHeader(key, value))
+		return nil
+	}
+}
+
+// hasRetryHeader reports whether h has any of the WithRetryOnHeader headers
+func (req *Request) hasRetryHeader(h http.Header) bool {
+	for _, retryHeader := range req.retryOnHeaders {
+		for _, value := range h[http.CanonicalHeaderKey(retryHeader.key)] {
+			if strings.EqualFold(strings.TrimSpace(value), retryHeader.value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// WithFallbackURLs fails the Request over to each of the urls in order,
+// once all attempts against the previous url have errored or returned a 5xx status code
+// The method, headers, params and payload are reused for each url, with the params added to its own query
+// NOTE: each url gets the full max attempts, so the total attempt budget is multiplied by len(urls) + 1
+func WithFallbackURLs(urls ...string) RequestOption {
+	return func(c context.Context, req *Request) error {
+		for _, u := range urls {
+			if _, err := url.Parse(u); err != nil {
+				return err
+			}
+		}
+		req.fallbackURLs = append(req.fallbackURLs, urls...)
+		return nil
+	}
+}
+
+// useFallbackURL points the Request at fallbackURL, adding the params to its query and replaying the payload
+func (req *Request) useFallbackURL(fallbackURL string) error {
+	u, err := url.Parse(fallbackURL)
+	if err != nil {
+		return err
+	}
+	// keep the fallback's own query, rather than sending the primary url's query to another host
+	if len(req.params) > 0 {
+		req.addParams(u)
+	}
+
+	fallback := req.request.Clone(req.request.Context())
+	fallback.URL = u
+	if req.host == "" {
+		fallback.Host = u.Host
+	}
+	if fallback.GetBody != nil {
+		if fallback.Body, err = fallback.GetBody(); err != nil {
+			return err
+		}
+	}
+
+	req.request = fallback
+	req.url = u.String()
+	return nil
+}
+
 // WithMultipartField adds the fieldname and value to the multipart fields
 func WithMultipartField(fieldname, value string) RequestOption {
 	return func(c context.Context, req *Request) error {
@@ -313,12 +898,9 @@ func WithMultipartField(fieldname, value string) RequestOption {
 	}
 }
 
-// WithReaderMultipartPayload takes a filepath, opens the file and adds it to the request with the fieldname
+// WithReaderMultipartPayload adds the data to the multipart payload as a file with the fieldname and filename
 func WithReaderMultipartPayload(fieldname, filename string, data io.Reader) RequestOption {
-	return func(c context.Context, req *Request) error {
-		req.multipartPayload(c, fieldname, filename, data)
-		return nil
-	}
+	return WithMultipartPart(fieldname, filename, "application/octet-stream", data)
 }
 
 // WithFilepathMultipartPayload takes a filepath, opens the file and adds it to the request with the fieldname
@@ -331,16 +913,45 @@ func WithFilepathMultipartPayload(fieldname, filepath string) RequestOption {
 
 		fi, err := f.Stat()
 		if err != nil {
+			f.Close()
 			return err
 		}
 
-		req.multipartPayload(c, fieldname, fi.Name(), f)
+		return WithReaderMultipartPayload(fieldname, fi.Name(), f)(c, req)
+	}
+}
+
+// WithMultipartPart adds the data to the multipart payload as a part with the fieldname, filename and Content-Type,
+// e.g. for APIs expecting an image/png part. The filename and Content-Type headers are left out if they're empty
+// Parts are written in the order they're added, after any WithMultipartField fields, and data is closed once written if it's an io.Closer
+func WithMultipartPart(fieldname, filename, contentType string, data io.Reader) RequestOption {
+	return func(c context.Context, req *Request) error {
+		header := textproto.MIMEHeader{}
+		disposition := fmt.Sprintf(`form-data; name="%s"`, multipartQuoteEscaper.Replace(fieldname))
+		if filename != "" {
+			disposition += fmt.Sprintf(`; filename="%s"`, multipartQuoteEscaper.Replace(filename))
+		}
+		header.Set("Content-Disposition", disposition)
+		if contentType != "" {
+			header.Set(ContentTypeHeader, contentType)
+		}
+		req.multipartParts = append(req.multipartParts, multipartPart{header: header, data: data})
 		return nil
 	}
 }
 
+// multipartQuoteEscaper escapes the quoted Content-Disposition values, like multipart.Writer.CreateFormFile
+var multipartQuoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+// multipartPart is a part of the multipart payload, with its headers
+type multipartPart struct {
+	header textproto.MIMEHeader
+	data   io.Reader
+}
+
+// multipartPayload streams the multipart fields and parts as the payload, written through a pipe by a goroutine
 // TODO: this still buffers internally - see https://groups.google.com/forum/#!topic/golang-nuts/Zjg5l4nKcQ0
-func (req *Request) multipartPayload(c context.Context, fieldname, filename string, data io.Reader) {
+func (req *Request) multipartPayload(c context.Context) {
 	// create a pipe to connect the data reader to the request payload
 	pipeReader, pipeWriter := io.Pipe()
 	mpw := multipart.NewWriter(pipeWriter)
@@ -348,72 +959,80 @@ func (req *Request) multipartPayload(c context.Context, fieldname, filename stri
 	// set multipart request options
 	req.optMultiPartForm = true
 
-	// set the multipart fields
-	for i := range req.multiPartFormFieldParams {
-		fldErr := mpw.WriteField(req.multiPartFormFieldParams[i].key, req.multiPartFormFieldParams[i].value)
-		if fldErr != nil {
-			req.multiPartFormErr = fldErr
-			req.errorf("mpw.WriteField failed: %s", fldErr.Error())
-			return
-		}
-	}
-
 	// set the payload
 	req.payload = pipeReader
+	req.payloadPipe = pipeReader
 	req.headers = append(req.headers, newHeader(ContentTypeHeader, mpw.FormDataContentType()))
 
-	part, err := mpw.CreateFormFile(fieldname, filename)
-	if err != nil {
-		req.multiPartFormErr = err
-		req.errorf("mpw.CreateFormFile failed: %s", err.Error())
-		return
-	}
-
 	// go routine the remainder of the multipart payload creation process
-	go copyMultipartToPipeWriter(c, req, pipeWriter, mpw, data, part)
+	go copyMultipartToPipeWriter(c, req, pipeWriter, mpw)
 }
 
-func copyMultipartToPipeWriter(c context.Context, req *Request, pipeWriter *io.PipeWriter, mpw *multipart.Writer, data io.Reader, part io.Writer) {
-	defer pipeWriter.Close()
-	defer mpw.Close()
-	if closer, ok := data.(io.Closer); ok {
-		defer closer.Close()
-	}
-
-	errChan := make(chan error)
+func copyMultipartToPipeWriter(c context.Context, req *Request, pipeWriter *io.PipeWriter, mpw *multipart.Writer) {
+	errChan := make(chan error, 1)
 	go func(errChan chan<- error) {
-		var err error
-		if _, err = io.Copy(part, data); err != nil {
+		errChan <- req.writeMultipart(mpw)
+	}(errChan)
+
+	// the pipe is closed with any error, so the request fails rather than sending a truncated payload
+	select {
+	case err := <-errChan:
+		if err != nil {
 			req.multiPartFormErr = err
-			req.errorf("io.Copy failed: %s", err.Error())
-			errChan <- err
-			return
+			req.errorf("writing the multipart payload failed: %s", err.Error())
 		}
+		pipeWriter.CloseWithError(err)
+	case <-c.Done():
+		req.debugf("context cancelled during copyMultipartToPipeWriter")
+		pipeWriter.CloseWithError(c.Err())
+	}
+}
 
-		if err = mpw.Close(); err != nil {
-			req.multiPartFormErr = err
-			req.errorf("mpw.Close failed: %s", err.Error())
-			errChan <- err
-			return
+// writeMultipart writes the multipart fields and then the parts to mpw
+func (req *Request) writeMultipart(mpw *multipart.Writer) error {
+	for _, part := range req.multipartParts {
+		if closer, ok := part.data.(io.Closer); ok {
+			defer closer.Close()
 		}
+	}
 
-		errChan <- nil
-	}(errChan)
+	for i := range req.multiPartFormFieldParams {
+		if err := mpw.WriteField(req.multiPartFormFieldParams[i].key, req.multiPartFormFieldParams[i].value); err != nil {
+			return err
+		}
+	}
 
-	select {
-	case <-errChan:
-		return
-	case <-c.Done():
-		req.debugf("context cancelled during copyMultipartToPipeWriter")
-		return
+	for _, part := range req.multipartParts {
+		w, err := mpw.CreatePart(part.header)
+		if err != nil {
+			return err
+		}
+		if _, err = io.Copy(w, part.data); err != nil {
+			return err
+		}
 	}
+
+	return mpw.Close()
 }
 
-// isErrBreaking returns false if the given error is involved with an option called by the user
+// isConnectionReset reports whether err was caused by the server resetting the connection
+func isConnectionReset(err error) bool {
+	return errors.Is(err, syscall.ECONNRESET)
+}
+
+// isErrBreaking returns false if the given error is transient, or involved with an option called by the user
+// Errors returned from http.Client.Do are wrapped in *url.Error, so the checks unwrap them
 func (req *Request) isErrBreaking(err error) bool {
+	var netErr net.Error
 	switch {
-	case strings.Contains(err.Error(), "read: connection reset by peer"),
-		req.retryOnEOFError && err == io.EOF:
+	case req.retryOnConnectionReset && isConnectionReset(err),
+		req.retryOnEOFError && errors.Is(err, io.EOF),
+		errors.Is(err, ErrAttemptTimeout):
+		return false
+	// a done context also reports as a timeout, but no further attempts can be made with it
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return true
+	case errors.As(err, &netErr) && netErr.Timeout():
 		return false
 	default:
 		return true
@@ -422,10 +1041,7 @@ func (req *Request) isErrBreaking(err error) bool {
 
 // WithReaderPayload sets the given payload for the Request
 func WithReaderPayload(payload io.Reader) RequestOption {
-	return func(c context.Context, req *Request) error {
-		req.payload = payload
-		return nil
-	}
+	return WithBody(payload, "")
 }
 
 // WithHeader adds the given key/value combo to the Request headers
@@ -436,6 +1052,97 @@ func WithHeader(key, value string) RequestOption {
 	}
 }
 
+// WithContextHeaderFunc adds the headers returned by fn for the context given to NewRequest (or Get, Post etc.),
+// e.g. to propagate trace ids stashed in the context by middleware. Headers with empty values are skipped
+// Give it to the client with WithRequestOptions to add the headers to every Request
+func WithContextHeaderFunc(fn func(c context.Context) map[string]string) RequestOption {
+	return func(c context.Context, req *Request) error {
+		headers := fn(c)
+		keys := make([]string, 0, len(headers))
+		for key := range headers {
+			keys = append(keys, key)
+		}
+		// add them in a stable order, since the map order is random
+		sort.Strings(keys)
+		for _, key := range keys {
+			if headers[key] != "" {
+				req.headers = append(req.headers, newHeader(key, headers[key]))
+			}
+		}
+		return nil
+	}
+}
+
+// WithHostHeader sends the given host in the Host header instead of the url host, e.g. for virtual hosting behind a gateway
+// NOTE: WithHeader can't be used for this, since the Host header is taken from the http.Request Host field
+func WithHostHeader(host string) RequestOption {
+	return func(c context.Context, req *Request) error {
+		req.host = host
+		return nil
+	}
+}
+
+// WithChunkedEncoding sends the payload with chunked Transfer-Encoding and no Content-Length,
+// for APIs that require chunked uploads even when the payload length is known
+func WithChunkedEncoding() RequestOption {
+	return func(c context.Context, req *Request) error {
+		req.optChunked = true
+		return nil
+	}
+}
+
+// WithIfMatch adds If-Match: etag to the Request headers, for optimistic concurrency on updates
+// GET the resource and keep its resp.ETag(), then PUT the change WithIfMatch(etag)
+// A 412 Precondition Failed response means the resource changed since the GET, so refetch it and try again
+func WithIfMatch(etag string) RequestOption {
+	return WithHeader(IfMatchHeader, etag)
+}
+
+// WithAcceptLanguage sets the Accept-Language header to langs weighted in the given order of preference,
+// e.g. WithAcceptLanguage("en-US", "en", "fr") sends "en-US,en;q=0.9,fr;q=0.8"
+func WithAcceptLanguage(langs ...string) RequestOption {
+	return func(c context.Context, req *Request) error {
+		if len(langs) == 0 {
+			return nil
+		}
+		req.headers = append(req.headers, newHeader(AcceptLanguageHeader, weightedValues(langs)))
+		return nil
+	}
+}
+
+// weightedValues joins values into a header list with quality values decreasing by 0.1 in order of preference,
+// with the first value left at the implicit q=1 and any after the tenth value all sharing q=0.1
+func weightedValues(values []string) string {
+	var sb strings.Builder
+	for i, value := range values {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(strings.TrimSpace(value))
+		if i > 0 {
+			q := 10 - i
+			if q < 1 {
+				q = 1
+			}
+			fmt.Fprintf(&sb, ";q=0.%d", q)
+		}
+	}
+	return sb.String()
+}
+
+// WithAccept sets the Accept header to types weighted in the given order of preference,
+// e.g. WithAccept(ContentTypeJSON, ContentTypeXML) sends "application/json,application/xml;q=0.9"
+// Decode detects the decoder from the Content-Type of whichever type the server responds with
+func WithAccept(types ...string) RequestOption {
+	return func(c context.Context, req *Request) error {
+		if len(types) == 0 {
+			return nil
+		}
+		req.headers = append(req.headers, newHeader(AcceptHeader, weightedValues(types)))
+		return nil
+	}
+}
+
 // WithAcceptJSONHeader adds Accept: application/json to the Request headers
 func WithAcceptJSONHeader() RequestOption {
 	return func(c context.Context, req *Request) error {
@@ -456,6 +1163,18 @@ func WithMaxAttempts(maxAttempts int) RequestOption {
 	}
 }
 
+// WithMaxAttemptsStrict sets the max number of times to attempt the Request on 5xx status code like WithMaxAttempts,
+// but returns an error for less than 1 rather than using 1, so a miscomputed value fails NewRequest
+func WithMaxAttemptsStrict(maxAttempts int) RequestOption {
+	return func(c context.Context, req *Request) error {
+		if maxAttempts < 1 {
+			return fmt.Errorf("max attempts must be at least 1, got %d", maxAttempts)
+		}
+		req.maxAttempts = maxAttempts
+		return nil
+	}
+}
+
 // WithAfterDoFunc allows user-defined functions to access Request and Response (read-only)
 func WithAfterDoFunc(afterDoFunc func(req *Request, resp *Response) error) RequestOption {
 	return func(c context.Context, req *Request) error {
@@ -464,6 +1183,51 @@ func WithAfterDoFunc(afterDoFunc func(req *Request, resp *Response) error) Reque
 	}
 }
 
+// WithExpectStatus makes Do return a *StatusError, including the body, if the response status code isn't one of codes
+// Unlike retries on 5xx status codes, this is checked once the final response has been received
+func WithExpectStatus(codes ...int) RequestOption {
+	return WithAfterDoFunc(func(req *Request, resp *Response) error {
+		for _, code := range codes {
+			if resp.StatusCode() == code {
+				return nil
+			}
+		}
+		body, _ := resp.Bytes()
+		return &StatusError{
+			StatusCode: resp.StatusCode(),
+			Expected:   codes,
+			Body:       body,
+		}
+	})
+}
+
+// WithFailOnStatus makes Do return a *StatusError, including the body, if the response status code is one of codes,
+// even a 2xx one, e.g. for APIs that respond 200 with an error envelope. It's checked once the final response has been received
+func WithFailOnStatus(codes ...int) RequestOption {
+	return WithAfterDoFunc(func(req *Request, resp *Response) error {
+		for _, code := range codes {
+			if resp.StatusCode() == code {
+				body, _ := resp.Bytes()
+				return &StatusError{
+					StatusCode: resp.StatusCode(),
+					Body:       body,
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// WithOnRetry calls onRetry each time an attempt has failed and another will be made,
+// with the failed attempt number, its Response (nil on error) or error, and the delay before the next attempt
+// The Response body is closed once onRetry returns
+func WithOnRetry(onRetry func(attempt int, resp *Response, err error, nextDelay time.Duration)) RequestOption {
+	return func(c context.Context, req *Request) error {
+		req.onRetryFunc = onRetry
+		return nil
+	}
+}
+
 // WithDefaultBackoff uses ExponentialJitterBackoff with min: 1s and max: 30s
 func WithDefaultBackoff() RequestOption {
 	return func(c context.Context, req *Request) error {
@@ -550,6 +1314,99 @@ func WithDeadline(deadline time.Time) RequestOption {
 	}
 }
 
+// WithAttemptTimeout bounds each attempt's wait for a response to the given duration,
+// unlike WithTimeout which bounds the whole Request including all retries
+// An attempt that times out is retried
+func WithAttemptTimeout(timeout time.Duration) RequestOption {
+	return func(c context.Context, req *Request) error {
+		req.attemptTimeout = timeout
+		return nil
+	}
+}
+
+// WithDeadlineHeader sets headerName on each attempt to the milliseconds left before the context deadline,
+// or the attempt timeout if that's sooner, so the server can give up on a request the client won't wait for
+// The header isn't sent when there's no deadline
+func WithDeadlineHeader(headerName string) RequestOption {
+	return func(c context.Context, req *Request) error {
+		req.deadlineHeader = headerName
+		return nil
+	}
+}
+
+// setDeadlineHeader sets the WithDeadlineHeader header to the time budget left for the attempt
+func (req *Request) setDeadlineHeader(c context.Context, reqc *http.Request) {
+	if req.deadlineHeader == "" {
+		return
+	}
+
+	var remaining time.Duration
+	deadline, ok := c.Deadline()
+	switch {
+	case ok:
+		remaining = time.Until(deadline)
+		if req.attemptTimeout > 0 && req.attemptTimeout < remaining {
+			remaining = req.attemptTimeout
+		}
+	case req.attemptTimeout > 0:
+		remaining = req.attemptTimeout
+	default:
+		return
+	}
+	if remaining < 0 {
+		remaining = 0
+	}
+	reqc.Header.Set(req.deadlineHeader, strconv.FormatInt(int64(remaining/time.Millisecond), 10))
+}
+
+// WithHedging sends a duplicate of an attempt if it hasn't got a response after the given duration, up to maxHedged times,
+// and uses whichever response arrives first, cancelling the others, to cut tail latency ("The Tail at Scale")
+// Only idempotent methods (GET, HEAD, OPTIONS, PUT and DELETE) can be hedged, since the server may receive every duplicate
+// NOTE: WithAttemptTimeout doesn't apply to hedged attempts
+func WithHedging(after time.Duration, maxHedged int) RequestOption {
+	return func(c context.Context, req *Request) error {
+		switch req.method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		default:
+			return fmt.Errorf("can't hedge %s requests, which aren't idempotent", req.method)
+		}
+		req.hedgeAfter = after
+		req.maxHedged = maxHedged
+		return nil
+	}
+}
+
+// WithNonceHeader sets headerName to a new value from gen before each attempt, including retries and fallbacks,
+// for APIs that reject a replayed nonce. Unlike an idempotency key, the value is never reused
+func WithNonceHeader(headerName string, gen func() string) RequestOption {
+	return func(c context.Context, req *Request) error {
+		req.nonceHeader = headerName
+		req.nonceFunc = gen
+		return nil
+	}
+}
+
+// WithRefreshOn401 calls fn once when the response is a 401, then resends the request a single time,
+// e.g. for fn to refresh a token kept in the http.Client transport or cookie jar
+// The resend doesn't count towards WithMaxAttempts, and a second 401 is returned as-is
+// An error returned by fn is returned by Do
+// NOTE: the resent request has the same headers, so credentials set with WithHeader or WithBasicAuth aren't updated
+func WithRefreshOn401(fn func(c context.Context) error) RequestOption {
+	return func(c context.Context, req *Request) error {
+		req.refreshOn401 = fn
+		return nil
+	}
+}
+
+// WithTransportName sends the Request with the transport registered under name through WithClientTransports,
+// instead of the client's default transport. Do returns an error if no transport is registered under name
+func WithTransportName(name string) RequestOption {
+	return func(c context.Context, req *Request) error {
+		req.transportName = name
+		return nil
+	}
+}
+
 // WithClientTrace is a convenience function around httptrace.WithClientTrace
 func WithClientTrace(clientTrace *httptrace.ClientTrace) RequestOption {
 	return func(c context.Context, req *Request) error {
@@ -4,9 +4,16 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"io"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
+	"os"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 )
 
 func TestNewRequest(t *testing.T) {
@@ -120,6 +127,174 @@ func TestNewRequest(t *testing.T) {
 			},
 			false,
 		},
+		{
+			"PATCH with JSON Patch payload",
+			&Client{},
+			args{
+				c:      ctx,
+				method: http.MethodPatch,
+				url:    "http://mywebsite.com",
+				opts: []RequestOption{WithJSONPatchPayload([]PatchOp{
+					{Op: "replace", Path: "/count", Value: 30},
+					{Op: "remove", Path: "/url"},
+					{Op: "replace", Path: "/a", Value: nil},
+					{Op: "move", From: "/b", Path: "/c"},
+				})},
+			},
+			&Request{
+				method:      "PATCH",
+				url:         "http://mywebsite.com",
+				maxAttempts: 1,
+				headers: []header{
+					{
+						key:   "Accept",
+						value: "application/json",
+					},
+					{
+						key:   "Content-Type",
+						value: "application/json-patch+json",
+					},
+				},
+				payload: bytes.NewBufferString(`[{"op":"replace","path":"/count","value":30},{"op":"remove","path":"/url"},{"op":"replace","path":"/a","value":null},{"op":"move","path":"/c","from":"/b"}]` + "\n"),
+			},
+			false,
+		},
+		{
+			"PATCH with JSON Merge Patch payload",
+			&Client{},
+			args{
+				c:      ctx,
+				method: http.MethodPatch,
+				url:    "http://mywebsite.com",
+				opts:   []RequestOption{WithJSONMergePatchPayload(map[string]interface{}{"count": 30})},
+			},
+			&Request{
+				method:      "PATCH",
+				url:         "http://mywebsite.com",
+				maxAttempts: 1,
+				headers: []header{
+					{
+						key:   "Accept",
+						value: "application/json",
+					},
+					{
+						key:   "Content-Type",
+						value: "application/merge-patch+json",
+					},
+				},
+				payload: bytes.NewBufferString(`{"count":30}` + "\n"),
+			},
+			false,
+		},
+		{
+			"POST with registered XML encoder",
+			&Client{},
+			args{
+				c:      ctx,
+				method: http.MethodPost,
+				url:    "http://mywebsite.com",
+				opts:   []RequestOption{WithPayload(ContentTypeXML, testObject{URL: "https://nozzle.io/", Count: 30})},
+			},
+			&Request{
+				method:      "POST",
+				url:         "http://mywebsite.com",
+				maxAttempts: 1,
+				headers: []header{
+					{
+						key:   "Accept",
+						value: "application/xml",
+					},
+					{
+						key:   "Content-Type",
+						value: "application/xml",
+					},
+				},
+				payload: bytes.NewBufferString(`<testObject><URL>https://nozzle.io/</URL><Count>30</Count></testObject>`),
+			},
+			false,
+		},
+		{
+			"POST with unregistered encoder",
+			&Client{},
+			args{
+				c:      ctx,
+				method: http.MethodPost,
+				url:    "http://mywebsite.com",
+				opts:   []RequestOption{WithPayload("application/x-unknown", "payload")},
+			},
+			nil,
+			true,
+		},
+		{
+			"POST WithBody string",
+			&Client{},
+			args{
+				c:      ctx,
+				method: http.MethodPost,
+				url:    "http://mywebsite.com",
+				opts:   []RequestOption{WithBody("plain text", "text/plain")},
+			},
+			&Request{
+				method:      "POST",
+				url:         "http://mywebsite.com",
+				maxAttempts: 1,
+				headers: []header{
+					{
+						key:   "Content-Type",
+						value: "text/plain",
+					},
+				},
+				payload: bytes.NewBufferString("plain text"),
+			},
+			false,
+		},
+		{
+			"POST WithBody struct",
+			&Client{},
+			args{
+				c:      ctx,
+				method: http.MethodPost,
+				url:    "http://mywebsite.com",
+				opts:   []RequestOption{WithBody(testObject{URL: "https://nozzle.io/", Count: 30}, ContentTypeJSON)},
+			},
+			&Request{
+				method:      "POST",
+				url:         "http://mywebsite.com",
+				maxAttempts: 1,
+				headers: []header{
+					{
+						key:   "Accept",
+						value: "application/json",
+					},
+					{
+						key:   "Content-Type",
+						value: "application/json",
+					},
+				},
+				payload: bytes.NewBufferString(`{"URL":"https://nozzle.io/","Count":30}` + "\n"),
+			},
+			false,
+		},
+		{
+			"GET with typed params",
+			&Client{},
+			args{
+				c:      ctx,
+				method: http.MethodGet,
+				url:    "http://mywebsite.com",
+				opts: []RequestOption{
+					WithParamInt("count", 30),
+					WithParamBool("active", true),
+					WithParamTime("since", time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC), time.RFC3339),
+				},
+			},
+			&Request{
+				method:      "GET",
+				url:         "http://mywebsite.com?active=true&count=30&since=2019-01-02T03%3A04%3A05Z",
+				maxAttempts: 1,
+			},
+			false,
+		},
 		{
 			"erroring option - GET",
 			&Client{},
@@ -171,3 +346,272 @@ func TestNewRequest(t *testing.T) {
 		})
 	}
 }
+
+func TestRequestStringRedactsHeaders(t *testing.T) {
+	c := context.Background()
+	cl := &Client{}
+	req, err := cl.NewRequest(c, http.MethodGet, "http://mywebsite.com",
+		WithHeader("Authorization", "Bearer secret"),
+		WithHeader("X-Api-Key", "secret"),
+		WithHeader("Accept", "application/json"),
+		WithRedactHeaders("x-api-key"),
+	)
+	if err != nil {
+		t.Fatalf("NewRequest() error = %v", err)
+	}
+
+	got := req.String()
+	if strings.Contains(got, "secret") {
+		t.Errorf("String() = %s, want sensitive headers redacted", got)
+	}
+	if !strings.Contains(got, "{Accept application/json}") {
+		t.Errorf("String() = %s, want non-sensitive headers", got)
+	}
+}
+
+func TestRequestEqualDoesNotConsumePayload(t *testing.T) {
+	c := context.Background()
+	cl := &Client{}
+	tests := []struct {
+		name string
+		opt  func() RequestOption
+	}{
+		{
+			"JSON payload",
+			func() RequestOption { return WithJSONPayload(testObject{URL: "https://nozzle.io/", Count: 30}) },
+		},
+		{
+			"streaming payload",
+			func() RequestOption {
+				return WithReaderPayload(io.MultiReader(strings.NewReader(`{"URL":"https://nozzle.io/","Count":30}` + "\n")))
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := cl.NewRequest(c, http.MethodPost, "http://mywebsite.com", tt.opt())
+			if err != nil {
+				t.Fatalf("NewRequest() error = %v", err)
+			}
+			reqComp, err := cl.NewRequest(c, http.MethodPost, "http://mywebsite.com", tt.opt())
+			if err != nil {
+				t.Fatalf("NewRequest() error = %v", err)
+			}
+
+			// compare twice to ensure neither comparison consumes the payloads
+			for i := 0; i < 2; i++ {
+				if equal, info := req.Equal(reqComp); !equal {
+					t.Fatalf("Equal() = false, info: %s", info)
+				}
+			}
+
+			body, err := ioutil.ReadAll(req.request.Body)
+			if err != nil {
+				t.Fatalf("reading body failed: %v", err)
+			}
+			if string(body) != `{"URL":"https://nozzle.io/","Count":30}`+"\n" {
+				t.Errorf("body = %q, want the full payload", body)
+			}
+		})
+	}
+}
+
+func TestIsErrBreaking(t *testing.T) {
+	wrap := func(err error) error {
+		return &url.Error{Op: "Get", URL: "http://example.com", Err: err}
+	}
+	reset := wrap(&net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)})
+	timeout := wrap(&net.DNSError{Err: "i/o timeout", Name: "example.com", IsTimeout: true})
+
+	tests := []struct {
+		name string
+		opts []RequestOption
+		err  error
+		want bool
+	}{
+		{"connection reset", nil, reset, false},
+		{"connection reset disabled", []RequestOption{WithRetryOnConnectionReset(false)}, reset, true},
+		{"EOF", nil, wrap(io.EOF), true},
+		{"EOF with option", []RequestOption{WithRetryOnEOFError()}, wrap(io.EOF), false},
+		{"network timeout", nil, timeout, false},
+		{"context deadline", nil, wrap(context.DeadlineExceeded), true},
+		{"context canceled", nil, wrap(context.Canceled), true},
+		{"other", nil, wrap(errors.New("unsupported protocol scheme")), true},
+	}
+	cl := &Client{}
+	for _, tt := range tests {
+		req, err := cl.NewRequest(context.Background(), http.MethodGet, "http://example.com", tt.opts...)
+		if err != nil {
+			t.Fatalf("%s: NewRequest failed: %v", tt.name, err)
+		}
+		if got := req.isErrBreaking(tt.err); got != tt.want {
+			t.Errorf("%s: isErrBreaking(%v) = %v, want %v", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizedURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		opts []RequestOption
+		want string
+	}{
+		{"https://example.com//api///v1/items", nil, "https://example.com/api/v1/items"},
+		{"https://example.com/api/./v1/../v2/items", nil, "https://example.com/api/v2/items"},
+		{"https://example.com/api//items/", nil, "https://example.com/api/items/"},
+		{"https://example.com/api/items?q=a//b", nil, "https://example.com/api/items?q=a//b"},
+		{"https://example.com", nil, "https://example.com"},
+		{"/items", []RequestOption{WithBaseURL("https://example.com/")}, "https://example.com/items"},
+	}
+	cl := &Client{}
+	for _, tt := range tests {
+		opts := append(tt.opts, WithNormalizedURL())
+		req, err := cl.NewRequest(context.Background(), http.MethodGet, tt.url, opts...)
+		if err != nil {
+			t.Fatalf("%s: NewRequest failed: %v", tt.url, err)
+		}
+		if got := req.request.URL.String(); got != tt.want {
+			t.Errorf("%s: normalized url = %s, want %s", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestBaseURL(t *testing.T) {
+	tests := []struct {
+		baseURL string
+		url     string
+		want    string
+	}{
+		{"https://example.com", "/items", "https://example.com/items"},
+		{"https://example.com/", "/items", "https://example.com/items"},
+		{"https://example.com/api/v1", "items", "https://example.com/api/v1/items"},
+		{"https://example.com/api/v1/", "/items/1", "https://example.com/api/v1/items/1"},
+		{"https://example.com/api", "", "https://example.com/api"},
+		{"https://example.com/api", "https://other.com/items", "https://other.com/items"},
+		{"https://example.com/api?key=abc", "/items", "https://example.com/api/items?key=abc"},
+		{"https://example.com/api?key=abc", "/items?page=2", "https://example.com/api/items?key=abc&page=2"},
+	}
+	cl := &Client{}
+	for _, tt := range tests {
+		req, err := cl.NewRequest(context.Background(), http.MethodGet, tt.url, WithBaseURL(tt.baseURL))
+		if err != nil {
+			t.Fatalf("%s + %s: NewRequest failed: %v", tt.baseURL, tt.url, err)
+		}
+		if got := req.request.URL.String(); got != tt.want {
+			t.Errorf("%s + %s: url = %s, want %s", tt.baseURL, tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestParamsMergeURLQuery(t *testing.T) {
+	cl := &Client{}
+	req, err := cl.NewRequest(context.Background(), http.MethodGet, "http://example.com/items?a=1&b=x",
+		WithParam("b", "2"),
+		WithParamInt("c", 3),
+	)
+	if err != nil {
+		t.Fatalf("NewRequest failed: %v", err)
+	}
+	if want := "http://example.com/items?a=1&b=x&b=2&c=3"; req.url != want {
+		t.Errorf("url = %s, want %s", req.url, want)
+	}
+}
+
+func TestFragment(t *testing.T) {
+	tests := []struct {
+		url  string
+		opts []RequestOption
+		want string
+	}{
+		{"http://example.com/items#top", []RequestOption{WithParam("a", "1")}, "http://example.com/items?a=1#top"},
+		{"http://example.com/items", []RequestOption{WithFragment("section-2"), WithParam("a", "1")}, "http://example.com/items?a=1#section-2"},
+		{"http://example.com/items#top", []RequestOption{WithFragment("bottom")}, "http://example.com/items#bottom"},
+	}
+	cl := &Client{}
+	for _, tt := range tests {
+		req, err := cl.NewRequest(context.Background(), http.MethodGet, tt.url, tt.opts...)
+		if err != nil {
+			t.Fatalf("%s: NewRequest failed: %v", tt.url, err)
+		}
+		if req.url != tt.want {
+			t.Errorf("%s: url = %s, want %s", tt.url, req.url, tt.want)
+		}
+	}
+}
+
+func TestAcceptLanguage(t *testing.T) {
+	tests := []struct {
+		langs []string
+		want  string
+	}{
+		{[]string{"en-US"}, "en-US"},
+		{[]string{"en-US", "en"}, "en-US,en;q=0.9"},
+		{[]string{"fr-CA", "fr", "en-US", "en"}, "fr-CA,fr;q=0.9,en-US;q=0.8,en;q=0.7"},
+		{[]string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l"}, "a,b;q=0.9,c;q=0.8,d;q=0.7,e;q=0.6,f;q=0.5,g;q=0.4,h;q=0.3,i;q=0.2,j;q=0.1,k;q=0.1,l;q=0.1"},
+	}
+	cl := &Client{}
+	for _, tt := range tests {
+		req, err := cl.NewRequest(context.Background(), http.MethodGet, "http://example.com", WithAcceptLanguage(tt.langs...))
+		if err != nil {
+			t.Fatalf("%v: NewRequest failed: %v", tt.langs, err)
+		}
+		if got := req.Header().Get(AcceptLanguageHeader); got != tt.want {
+			t.Errorf("%v: %s = %q, want %q", tt.langs, AcceptLanguageHeader, got, tt.want)
+		}
+	}
+}
+
+func TestMaxAttemptsStrict(t *testing.T) {
+	tests := []struct {
+		maxAttempts int
+		wantErr     bool
+	}{
+		{-1, true},
+		{0, true},
+		{1, false},
+		{3, false},
+	}
+	cl := &Client{}
+	for _, tt := range tests {
+		req, err := cl.NewRequest(context.Background(), http.MethodGet, "http://example.com", WithMaxAttemptsStrict(tt.maxAttempts))
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%d: NewRequest succeeded, want an error", tt.maxAttempts)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%d: NewRequest failed: %v", tt.maxAttempts, err)
+		}
+		if req.MaxAttempts() != tt.maxAttempts {
+			t.Errorf("%d: MaxAttempts() = %d", tt.maxAttempts, req.MaxAttempts())
+		}
+	}
+}
+
+func TestUnsortedQuery(t *testing.T) {
+	cl := &Client{}
+	opts := []RequestOption{
+		WithParam("z", "1"),
+		WithParam("a", "x y"),
+		WithParam("z", "2"),
+		WithParam("m&n", "é"),
+	}
+
+	req, err := cl.NewRequest(context.Background(), http.MethodGet, "http://example.com/items?q=b&c=d", append(opts, WithUnsortedQuery())...)
+	if err != nil {
+		t.Fatalf("NewRequest failed: %v", err)
+	}
+	if want := "http://example.com/items?q=b&c=d&z=1&a=x+y&z=2&m%26n=%C3%A9"; req.url != want {
+		t.Errorf("url = %s, want %s", req.url, want)
+	}
+
+	// sorted by default
+	req, err = cl.NewRequest(context.Background(), http.MethodGet, "http://example.com/items", opts...)
+	if err != nil {
+		t.Fatalf("NewRequest failed: %v", err)
+	}
+	if want := "http://example.com/items?a=x+y&m%26n=%C3%A9&z=1&z=2"; req.url != want {
+		t.Errorf("url = %s, want %s", req.url, want)
+	}
+}
@@ -1,12 +1,17 @@
 package fetcher
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strings"
+	"time"
 )
 
 // Response is returned after executing client.Do
@@ -19,22 +24,83 @@ type Response struct {
 	// set through Options
 	keepBody bool
 
+	// json.Decoder settings, set through WithStrictJSONBody and WithUseJSONNumber
+	jsonDisallowUnknownFields bool
+	jsonUseNumber             bool
+
+	// caller supplied buffer used instead of the pool, see WithReusableBuffer
+	reusableBuffer *bytes.Buffer
+
 	// used by Close()
 	bodyClosed bool
 
+	// the body was decompressed by WithDecompression, so it no longer matches the Content-Encoding and Content-Length headers
+	decompressed bool
+
+	// the decompressing readers wrapping the body that need closing, innermost first
+	decompressors []io.Closer
+
+	// counts the decompressed body as it's read, see UncompressedSize
+	uncompressed *countingReader
+
+	// time taken by Do to get the response, see Duration
+	duration time.Duration
+
+	// cancels the context of the http.Request, aborting any in-progress body read
+	bodyCancelFunc context.CancelFunc
+
 	decodeFunc DecodeFunc
 }
 
 // NewResponse returns a Response with the given Request and http.Response
 func NewResponse(c context.Context, req *Request, resp *http.Response) *Response {
-	return &Response{
+	r := &Response{
 		request:  req,
 		response: resp,
 		body:     resp.Body,
 	}
+	// the http.Transport decompressed the body transparently
+	if resp.Uncompressed {
+		r.countUncompressed()
+	}
+	r.limitBody()
+	return r
+}
+
+// limitBody caps the body at the WithClientMaxResponseBytes limit, if the client has one
+func (resp *Response) limitBody() {
+	if resp.request == nil || resp.request.client == nil || resp.request.client.maxResponseBytes <= 0 {
+		return
+	}
+	resp.body = &limitedReader{r: resp.body, limit: resp.request.client.maxResponseBytes, remaining: resp.request.client.maxResponseBytes}
+}
+
+// limitedReader reads from r, failing with ErrResponseTooLarge once more than limit bytes are read
+// Unlike io.LimitReader, it reports the overflow rather than a silently truncated body
+type limitedReader struct {
+	r         io.Reader
+	limit     int64
+	remaining int64
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	if lr.remaining < 0 {
+		return 0, fmt.Errorf("%w: over %d bytes", ErrResponseTooLarge, lr.limit)
+	}
+	// read one byte past the limit, so a body of exactly limit bytes still ends with io.EOF
+	if int64(len(p)) > lr.remaining+1 {
+		p = p[:lr.remaining+1]
+	}
+	n, err := lr.r.Read(p)
+	lr.remaining -= int64(n)
+	if lr.remaining < 0 {
+		return n + int(lr.remaining), fmt.Errorf("%w: over %d bytes", ErrResponseTooLarge, lr.limit)
+	}
+	return n, err
 }
 
 // Decode decodes the resp.response.Body into the given object (v) using the specified decoder
+// The body is closed once decoded, so a later Close only releases the Response's remaining resources
 // NOTE: v is assumed to be a pointer
 func (resp *Response) Decode(c context.Context, v interface{}, opts ...DecodeOption) error {
 	// execute all options
@@ -50,52 +116,270 @@ func (resp *Response) Decode(c context.Context, v interface{}, opts ...DecodeOpt
 		resp.decodeFunc = resp.detectDecoder()
 	}
 
-	defer resp.response.Body.Close()
+	defer resp.closeBody()
 
 	if resp.decodeFunc == nil {
 		return errors.New("no valid decoder specified")
 	}
 
-	return resp.decodeFunc(resp.body, v)
+	body, stop := resp.contextBody(c)
+	defer stop()
+
+	return resp.decodeFunc(body, v)
 }
 
-// detectDecoder auto-selects a decoder based on the response header
-func (resp *Response) detectDecoder() DecodeFunc {
-	switch resp.response.Header.Get(ContentTypeHeader) {
-	case ContentTypeJSON:
-		resp.request.debugf("json encoding detected")
-		return jsonDecodeFunc
+// contextBody returns resp.body wrapped so that c being done aborts any in-progress read,
+// by cancelling the context of the http.Request. The returned func must be called once reading is finished
+func (resp *Response) contextBody(c context.Context) (io.Reader, func()) {
+	if c.Done() == nil {
+		return resp.body, func() {}
+	}
+
+	done := make(chan struct{})
+	if resp.bodyCancelFunc != nil {
+		go func() {
+			select {
+			case <-c.Done():
+				resp.request.debugf("context cancelled while reading the response body")
+				resp.bodyCancelFunc()
+			case <-done:
+			}
+		}()
+	}
+
+	return &contextReader{c: c, r: resp.body}, func() { close(done) }
+}
 
-	case ContentTypeGob:
-		resp.request.debugf("gob encoding detected")
-		return gobDecodeFunc
+// contextReader returns the context error instead of the read error once its context is done
+type contextReader struct {
+	c context.Context
+	r io.Reader
+}
 
-	case ContentTypeXML:
-		resp.request.debugf("xml encoding detected")
-		return xmlDecodeFunc
+func (cr *contextReader) Read(p []byte) (int, error) {
+	if err := cr.c.Err(); err != nil {
+		return 0, err
+	}
+	n, err := cr.r.Read(p)
+	if err != nil && cr.c.Err() != nil {
+		return n, cr.c.Err()
 	}
+	return n, err
+}
 
-	return nil
+// detectDecoder auto-selects a registered decoder based on the response header
+func (resp *Response) detectDecoder() DecodeFunc {
+	contentType := resp.response.Header.Get(ContentTypeHeader)
+	decodeFunc, ok := getDecoder(contentType)
+	if !ok {
+		return nil
+	}
+	resp.request.debugf("%s encoding detected", normalizeContentType(contentType))
+	return decodeFunc
 }
 
 // Bytes reads the body into a buffer and then returns the bytes
-// returns error based on resp.response.Body.Close()
+// The body is closed once read, and returns error based on resp.response.Body.Close()
+// NOTE: reading is aborted when the context given to Do is done
 func (resp *Response) Bytes() ([]byte, error) {
 	if resp.copiedBody != nil {
 		return resp.copiedBody.Bytes(), nil
 	}
-	buf := getBuffer()
-	if _, err := buf.ReadFrom(resp.response.Body); err != nil {
+	// read straight into a caller supplied buffer, which needs no copy since it isn't returned to the pool
+	buf := resp.reusableBuffer
+	if buf != nil {
+		buf.Reset()
+	} else {
+		buf = getBuffer()
+		defer putBuffer(buf)
+	}
+	if _, err := buf.ReadFrom(resp.body); err != nil {
+		// e.g. ErrResponseTooLarge, so release the connection rather than leaving it to Close
+		resp.closeBody()
 		return nil, err
 	}
-	if err := resp.response.Body.Close(); err != nil {
+	if err := resp.closeBody(); err != nil {
 		return nil, err
 	}
-	resp.bodyClosed = true
-	resp.copiedBody = bytes.NewBufferString(buf.String())
+	// bodies with a Content-Length were already checked in Do
+	if resp.ContentLength() < 0 {
+		resp.request.checkLargeResponse(int64(buf.Len()))
+	}
+	// copy out of the pooled buffer, since the returned bytes outlive it
+	resp.copiedBody = buf
+	if buf != resp.reusableBuffer {
+		resp.copiedBody = bytes.NewBufferString(buf.String())
+	}
+	// allow the body to be decoded after it has been read
+	resp.body = bytes.NewReader(resp.copiedBody.Bytes())
 	return resp.copiedBody.Bytes(), nil
 }
 
+// BytesPartial reads the body like Bytes, but stops when c is done and returns the bytes read so far,
+// with truncated set and the context error, e.g. to resume a large download later with a Range request
+// Any other read error is returned with the bytes read before it, and truncated unset
+// NOTE: stopping the read aborts the request, so the rest of the body can't be read afterwards
+func (resp *Response) BytesPartial(c context.Context) ([]byte, bool, error) {
+	if resp.copiedBody != nil {
+		return resp.copiedBody.Bytes(), false, nil
+	}
+
+	// a blocked read only returns once the request is aborted, so abort it when c is done
+	readDone := make(chan struct{})
+	defer close(readDone)
+	go func() {
+		select {
+		case <-c.Done():
+			if resp.bodyCancelFunc != nil {
+				resp.bodyCancelFunc()
+			}
+		case <-readDone:
+		}
+	}()
+
+	buf := &bytes.Buffer{}
+	chunk := make([]byte, 32<<10)
+	for {
+		if c.Err() != nil {
+			resp.closeBody()
+			return buf.Bytes(), true, c.Err()
+		}
+		n, err := resp.body.Read(chunk)
+		buf.Write(chunk[:n])
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			resp.closeBody()
+			if c.Err() != nil {
+				return buf.Bytes(), true, c.Err()
+			}
+			return buf.Bytes(), false, err
+		}
+	}
+
+	if err := resp.closeBody(); err != nil {
+		return nil, false, err
+	}
+	if resp.ContentLength() < 0 {
+		resp.request.checkLargeResponse(int64(buf.Len()))
+	}
+	// allow the body to be decoded after it has been read
+	resp.copiedBody = buf
+	resp.body = bytes.NewReader(buf.Bytes())
+	return buf.Bytes(), false, nil
+}
+
+// Peek returns up to the first n bytes of the body without consuming them
+// The full body remains available to Decode, Bytes and Body
+func (resp *Response) Peek(n int) ([]byte, error) {
+	if resp.bodyClosed && resp.copiedBody != nil {
+		bts := resp.copiedBody.Bytes()
+		if len(bts) > n {
+			bts = bts[:n]
+		}
+		return bts, nil
+	}
+
+	br, ok := resp.body.(*bufio.Reader)
+	if !ok || br.Size() < n {
+		br = bufio.NewReaderSize(resp.body, n)
+		resp.body = br
+	}
+
+	peeked, err := br.Peek(n)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	// the peeked bytes are only valid until the next read, so return a copy
+	bts := make([]byte, len(peeked))
+	copy(bts, peeked)
+	return bts, nil
+}
+
+// hopByHopHeaders only apply to a single connection, so they're never copied by Pipe or NewRequestFromHTTP
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// Pipe streams the body to w without buffering it, then closes the Response, e.g. for proxying a response from a handler
+// The status code is written to w before the body, and if w is an http.Flusher each chunk is flushed as it's read,
+// so streamed responses (e.g. server-sent events) reach the client as they arrive
+// If copyHeaders is set, the response headers are copied to w first, except for:
+//   - hop-by-hop headers (Connection, Keep-Alive, Proxy-*, TE, Trailer, Transfer-Encoding and Upgrade)
+//   - any headers listed in the Connection header
+//   - Content-Encoding and Content-Length, if the body was decompressed by WithDecompression
+func (resp *Response) Pipe(w http.ResponseWriter, copyHeaders bool) (int64, error) {
+	if copyHeaders {
+		resp.copyHeaders(w.Header())
+	}
+	w.WriteHeader(resp.StatusCode())
+
+	var dst io.Writer = w
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+		dst = &flushWriter{w: w, flusher: flusher}
+	}
+	n, err := io.Copy(dst, resp.body)
+	if closeErr := resp.Close(); err == nil {
+		err = closeErr
+	}
+	return n, err
+}
+
+// flushWriter flushes after every write, and hides any io.ReaderFrom of w so io.Copy writes each chunk as it's read
+type flushWriter struct {
+	w       io.Writer
+	flusher http.Flusher
+}
+
+func (fw *flushWriter) Write(p []byte) (int, error) {
+	n, err := fw.w.Write(p)
+	fw.flusher.Flush()
+	return n, err
+}
+
+// hopByHopHeaderSet returns the hop-by-hop headers of h, including any listed in its Connection header
+func hopByHopHeaderSet(h http.Header) map[string]bool {
+	skip := map[string]bool{}
+	for _, key := range hopByHopHeaders {
+		skip[key] = true
+	}
+	for _, connectionHeader := range h[http.CanonicalHeaderKey("Connection")] {
+		for _, key := range strings.Split(connectionHeader, ",") {
+			skip[http.CanonicalHeaderKey(strings.TrimSpace(key))] = true
+		}
+	}
+	return skip
+}
+
+// copyHeaders adds the end-to-end response headers to dst
+func (resp *Response) copyHeaders(dst http.Header) {
+	skip := hopByHopHeaderSet(resp.response.Header)
+	if resp.decompressed {
+		skip[ContentEncodingHeader] = true
+		skip["Content-Length"] = true
+	}
+
+	for key, values := range resp.response.Header {
+		if skip[key] {
+			continue
+		}
+		for _, value := range values {
+			dst.Add(key, value)
+		}
+	}
+}
+
 // MustBytes reads the body into a buffer and then returns the bytes
 func (resp *Response) MustBytes() []byte {
 	bts, err := resp.Bytes()
@@ -105,29 +389,74 @@ func (resp *Response) MustBytes() []byte {
 	return bts
 }
 
-// Body returns the resp.response.Body as io.Reader
-// NOTE: original io.ReadCloser body is closed when Close is called by the user
+// Body returns the resp.response.Body as io.Reader, including any bytes buffered by Peek
+// NOTE: unlike Decode and Bytes, reading the body doesn't close it, so Close must be called by the user
 func (resp *Response) Body() io.Reader {
 	if resp.keepBody && resp.copiedBody != nil {
 		return resp.copiedBody
 	}
-	return resp.response.Body
+	return resp.body
 }
 
 // Close handles any needed clean-up after the user is done with the Response object
+// It's safe to call after Decode or Bytes have closed the body, and to call more than once
 func (resp *Response) Close() error {
+	// the pooled WithCopiedBody buffer is returned to the pool only once, and a WithReusableBuffer buffer never
 	if resp.keepBody && resp.copiedBody != nil {
-		putBuffer(resp.copiedBody)
+		if resp.copiedBody != resp.reusableBuffer {
+			putBuffer(resp.copiedBody)
+		}
+		resp.copiedBody = nil
+		resp.keepBody = false
 	}
+	if resp.bodyCancelFunc != nil {
+		defer resp.bodyCancelFunc()
+	}
+	return resp.closeBody()
+}
+
+// closeBody closes the http.Response body once, marking it closed so later calls are no-ops
+func (resp *Response) closeBody() error {
 	if resp.bodyClosed {
 		return nil
 	}
-	if err := resp.response.Body.Close(); err != io.EOF {
+	resp.bodyClosed = true
+	resp.drainBody()
+	err := resp.response.Body.Close()
+	// closed outermost first, once the body they read from is closed, so none is left waiting on a read
+	for i := len(resp.decompressors) - 1; i >= 0; i-- {
+		resp.decompressors[i].Close()
+	}
+	if err != io.EOF {
 		return err
 	}
 	return nil
 }
 
+// drainBody discards the unread body if it's small enough to be worth keeping the connection for, see WithDrainOnClose
+func (resp *Response) drainBody() {
+	if resp.request == nil || resp.request.client == nil {
+		return
+	}
+	maxBytes := resp.request.client.drainOnClose
+	if maxBytes <= 0 || resp.response.ContentLength < 0 || resp.response.ContentLength > maxBytes {
+		return
+	}
+
+	// the body is closed once the timeout passes, which ends the read
+	drained := make(chan struct{})
+	go func() {
+		io.CopyN(ioutil.Discard, resp.response.Body, maxBytes)
+		close(drained)
+	}()
+	timer := time.NewTimer(drainOnCloseTimeout)
+	defer timer.Stop()
+	select {
+	case <-drained:
+	case <-timer.C:
+	}
+}
+
 // StatusCode exports resp.StatusCode
 func (resp *Response) StatusCode() int {
 	return resp.response.StatusCode
@@ -138,14 +467,208 @@ func (resp *Response) Status() string {
 	return resp.response.Status
 }
 
-// FinalURL returns the final URL from resp.Request
+// IsSuccess reports whether the status code is 2xx
+func (resp *Response) IsSuccess() bool {
+	return resp.StatusCode() >= 200 && resp.StatusCode() < 300
+}
+
+// IsRedirect reports whether the status code is 3xx
+func (resp *Response) IsRedirect() bool {
+	return resp.StatusCode() >= 300 && resp.StatusCode() < 400
+}
+
+// IsClientError reports whether the status code is 4xx
+func (resp *Response) IsClientError() bool {
+	return resp.StatusCode() >= 400 && resp.StatusCode() < 500
+}
+
+// IsServerError reports whether the status code is 5xx
+func (resp *Response) IsServerError() bool {
+	return resp.StatusCode() >= 500 && resp.StatusCode() < 600
+}
+
+// FinalURL returns the URL of the request that produced the Response, after any redirects were followed
 func (resp *Response) FinalURL() *url.URL {
 	return resp.response.Request.URL
 }
 
-// RequestURL returns the resp.request.url
+// RequestURL returns the url exactly as given to NewRequest (or Get, Post etc.),
+// before WithBaseURL, params, normalization, fallbacks or redirects changed it
+// The url the Request was built with is returned by req.URL(), and the url after redirects by FinalURL
 func (resp *Response) RequestURL() string {
-	return resp.request.url
+	return resp.request.originalURL
+}
+
+// Redirected reports whether one or more redirects were followed to get the Response,
+// in which case FinalURL is the url of the last redirect rather than the url the Request was sent to
+func (resp *Response) Redirected() bool {
+	return resp.response.Request != nil && resp.response.Request.Response != nil
+}
+
+// ContentLength returns the length of the body as it's read, or -1 if unknown
+// A decompressed body is always unknown, since the Content-Length is the compressed size, see UncompressedSize
+func (resp *Response) ContentLength() int64 {
+	if resp.uncompressed != nil {
+		return -1
+	}
+	return resp.response.ContentLength
+}
+
+// UncompressedSize returns the size of the body after decompression by WithDecompression (or the http.Transport),
+// which is only known once the whole body has been read, returning -1 until then
+// The size of a body that wasn't compressed is its ContentLength
+func (resp *Response) UncompressedSize() int64 {
+	if resp.uncompressed == nil {
+		return resp.ContentLength()
+	}
+	if !resp.uncompressed.eof {
+		return -1
+	}
+	return resp.uncompressed.n
+}
+
+// countUncompressed wraps resp.body to count the decompressed bytes read
+func (resp *Response) countUncompressed() {
+	resp.uncompressed = &countingReader{r: resp.body}
+	resp.body = resp.uncompressed
+}
+
+// countingReader counts the bytes read from r, and whether it was read to the end
+type countingReader struct {
+	r   io.Reader
+	n   int64
+	eof bool
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	if err == io.EOF {
+		cr.eof = true
+	}
+	return n, err
+}
+
+// Header returns the headers of the Response
+func (resp *Response) Header() http.Header {
+	return resp.response.Header
+}
+
+// ThrottleDuration returns the total time the Request spent waiting on the client rate limit, across all attempts
+func (resp *Response) ThrottleDuration() time.Duration {
+	return resp.request.throttled
+}
+
+// Duration returns how long Do took to get the Response, including every retry, backoff and fallback,
+// but not reading the body. It's set before any afterDoFuncs run, so they can log or record it
+func (resp *Response) Duration() time.Duration {
+	return resp.duration
+}
+
+// AttemptRecord is the outcome of a single attempt of a Request
+type AttemptRecord struct {
+	// StatusCode is 0 if the attempt failed without a response
+	StatusCode int
+	Err        error
+
+	// Delay is the backoff before the next attempt, 0 for the last one
+	Delay time.Duration
+}
+
+// AttemptHistory returns a record of every attempt made to get the Response, in order, including those against fallback urls
+// and a resend after WithRefreshOn401, e.g. to look into intermittent failures of an upstream
+func (resp *Response) AttemptHistory() []AttemptRecord {
+	return resp.request.attemptHistory
+}
+
+// ETag returns the ETag header value of the Response, for use with WithIfMatch
+func (resp *Response) ETag() string {
+	return resp.response.Header.Get(ETagHeader)
+}
+
+// Links returns the RFC 8288 Link headers of the Response as a map of each rel to its url, e.g. "next" for pagination
+// Relative urls are resolved against FinalURL, and the first link for a rel is kept if there are several
+func (resp *Response) Links() map[string]string {
+	links := map[string]string{}
+	for _, value := range resp.response.Header["Link"] {
+		for value != "" {
+			var link string
+			var rels []string
+			link, rels, value = parseLink(value)
+			if link == "" {
+				continue
+			}
+			if u, err := resp.FinalURL().Parse(link); err == nil {
+				link = u.String()
+			}
+			for _, rel := range rels {
+				if _, ok := links[rel]; !ok {
+					links[rel] = link
+				}
+			}
+		}
+	}
+	return links
+}
+
+// parseLink parses the first link of a Link header value, returning its url, its (lowercased) rels and the rest of the value
+// An empty url is returned for a malformed link, which is skipped up to the next comma
+func parseLink(value string) (string, []string, string) {
+	value = strings.TrimLeft(value, " \t,")
+	if !strings.HasPrefix(value, "<") {
+		if i := strings.IndexByte(value, ','); i != -1 {
+			return "", nil, value[i+1:]
+		}
+		return "", nil, ""
+	}
+	end := strings.IndexByte(value, '>')
+	if end == -1 {
+		return "", nil, ""
+	}
+	link := value[1:end]
+	value = value[end+1:]
+
+	// the params run up to the next comma outside a quoted string
+	var rels []string
+	for {
+		value = strings.TrimLeft(value, " \t")
+		if !strings.HasPrefix(value, ";") {
+			break
+		}
+		value = strings.TrimLeft(value[1:], " \t")
+		i := strings.IndexAny(value, "=;,")
+		if i == -1 {
+			value = ""
+			break
+		}
+		// a param without a value
+		if value[i] != '=' {
+			value = value[i:]
+			continue
+		}
+		name := strings.ToLower(strings.TrimSpace(value[:i]))
+		value = strings.TrimLeft(value[i+1:], " \t")
+
+		var param string
+		if strings.HasPrefix(value, `"`) {
+			end := strings.IndexByte(value[1:], '"')
+			if end == -1 {
+				param, value = value[1:], ""
+			} else {
+				param, value = value[1:end+1], value[end+2:]
+			}
+		} else {
+			end := strings.IndexAny(value, ";,")
+			if end == -1 {
+				end = len(value)
+			}
+			param, value = strings.TrimSpace(value[:end]), value[end:]
+		}
+		if name == "rel" {
+			rels = append(rels, strings.Fields(strings.ToLower(param))...)
+		}
+	}
+	return link, rels, value
 }
 
 // ContentType returns the Content-Type header value of the Response
@@ -0,0 +1,181 @@
+package fetcher
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestResponseStatusClass(t *testing.T) {
+	tests := []struct {
+		statusCode                                              int
+		wantSuccess, wantRedirect, wantClientErr, wantServerErr bool
+	}{
+		{http.StatusOK, true, false, false, false},
+		{http.StatusNoContent, true, false, false, false},
+		{http.StatusMovedPermanently, false, true, false, false},
+		{http.StatusNotFound, false, false, true, false},
+		{http.StatusTooManyRequests, false, false, true, false},
+		{http.StatusServiceUnavailable, false, false, false, true},
+		{http.StatusContinue, false, false, false, false},
+	}
+	for _, tt := range tests {
+		resp := &Response{response: &http.Response{StatusCode: tt.statusCode}}
+		if got := resp.IsSuccess(); got != tt.wantSuccess {
+			t.Errorf("%d: IsSuccess() = %v, want %v", tt.statusCode, got, tt.wantSuccess)
+		}
+		if got := resp.IsRedirect(); got != tt.wantRedirect {
+			t.Errorf("%d: IsRedirect() = %v, want %v", tt.statusCode, got, tt.wantRedirect)
+		}
+		if got := resp.IsClientError(); got != tt.wantClientErr {
+			t.Errorf("%d: IsClientError() = %v, want %v", tt.statusCode, got, tt.wantClientErr)
+		}
+		if got := resp.IsServerError(); got != tt.wantServerErr {
+			t.Errorf("%d: IsServerError() = %v, want %v", tt.statusCode, got, tt.wantServerErr)
+		}
+	}
+}
+
+// closeCounter counts the Close calls on a response body
+type closeCounter struct {
+	io.Reader
+	closes int
+}
+
+func (cc *closeCounter) Close() error {
+	cc.closes++
+	return nil
+}
+
+func TestResponseCloseSemantics(t *testing.T) {
+	c := context.Background()
+	tests := []struct {
+		name   string
+		access func(resp *Response) error
+	}{
+		{"Decode", func(resp *Response) error {
+			var obj testObject
+			return resp.Decode(c, &obj, WithJSONBody())
+		}},
+		{"Bytes", func(resp *Response) error {
+			_, err := resp.Bytes()
+			return err
+		}},
+		{"Bytes then Decode", func(resp *Response) error {
+			if _, err := resp.Bytes(); err != nil {
+				return err
+			}
+			var obj testObject
+			return resp.Decode(c, &obj, WithJSONBody())
+		}},
+		{"Peek then Decode", func(resp *Response) error {
+			if _, err := resp.Peek(4); err != nil {
+				return err
+			}
+			var obj testObject
+			return resp.Decode(c, &obj, WithJSONBody())
+		}},
+		{"Decode WithCopiedBody", func(resp *Response) error {
+			var obj testObject
+			return resp.Decode(c, &obj, WithCopiedBody(), WithJSONBody())
+		}},
+		{"Body", func(resp *Response) error {
+			_, err := ioutil.ReadAll(resp.Body())
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := &closeCounter{Reader: strings.NewReader(`{"URL": "https://example.com", "Count": 1}`)}
+			resp := NewResponse(c, &Request{}, &http.Response{StatusCode: http.StatusOK, ContentLength: -1, Body: body})
+
+			if err := tt.access(resp); err != nil {
+				t.Fatalf("access failed: %v", err)
+			}
+			for i := 0; i < 2; i++ {
+				if err := resp.Close(); err != nil {
+					t.Fatalf("Close #%d failed: %v", i+1, err)
+				}
+			}
+			if !resp.bodyClosed || body.closes != 1 {
+				t.Errorf("bodyClosed = %v, body closed %d times, want true and once", resp.bodyClosed, body.closes)
+			}
+		})
+	}
+}
+
+func TestStrictJSONBody(t *testing.T) {
+	c := context.Background()
+	tests := []struct {
+		name    string
+		body    string
+		opt     DecodeOption
+		wantErr bool
+	}{
+		{"lenient", `{"URL": "https://example.com", "Count": 1, "Extra": true}`, WithJSONBody(), false},
+		{"strict", `{"URL": "https://example.com", "Count": 1}`, WithStrictJSONBody(), false},
+		{"strict with extra field", `{"URL": "https://example.com", "Count": 1, "Extra": true}`, WithStrictJSONBody(), true},
+	}
+	for _, tt := range tests {
+		resp := NewResponse(c, &Request{}, &http.Response{Body: ioutil.NopCloser(strings.NewReader(tt.body))})
+		var obj testObject
+		err := resp.Decode(c, &obj, tt.opt)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: Decode err = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+		if err != nil && !strings.Contains(err.Error(), `unknown field "Extra"`) {
+			t.Errorf("%s: Decode err = %v, want the unknown field named", tt.name, err)
+		}
+	}
+}
+
+func TestUseJSONNumber(t *testing.T) {
+	c := context.Background()
+	const body = `{"id": 9007199254740993}`
+
+	for _, tt := range []struct {
+		name string
+		opts []DecodeOption
+		want string
+	}{
+		{"float64", []DecodeOption{WithJSONBody()}, "9.007199254740992e+15"},
+		{"json.Number", []DecodeOption{WithUseJSONNumber()}, "9007199254740993"},
+		{"json.Number strict", []DecodeOption{WithStrictJSONBody(), WithUseJSONNumber()}, "9007199254740993"},
+	} {
+		resp := NewResponse(c, &Request{}, &http.Response{Body: ioutil.NopCloser(strings.NewReader(body))})
+		var v map[string]interface{}
+		if err := resp.Decode(c, &v, tt.opts...); err != nil {
+			t.Fatalf("%s: Decode failed: %v", tt.name, err)
+		}
+		if got := fmt.Sprint(v["id"]); got != tt.want {
+			t.Errorf("%s: id = %s (%T), want %s", tt.name, got, v["id"], tt.want)
+		}
+	}
+}
+
+func TestLinks(t *testing.T) {
+	header := http.Header{"Link": []string{
+		`<https://api.example.com/items?page=2>; rel="next", <https://api.example.com/items?page=9>; rel="last"`,
+		`</items?page=1>; crossorigin; rel="first PREV"; title="a, b", <https://api.example.com/items?page=3>; rel=next`,
+		`malformed, <https://example.com/docs>;rel=help`,
+	}}
+	requestURL, _ := url.Parse("https://api.example.com/items?page=1")
+	resp := &Response{response: &http.Response{Header: header, Request: &http.Request{URL: requestURL}}}
+
+	want := map[string]string{
+		"next":  "https://api.example.com/items?page=2",
+		"last":  "https://api.example.com/items?page=9",
+		"first": "https://api.example.com/items?page=1",
+		"prev":  "https://api.example.com/items?page=1",
+		"help":  "https://example.com/docs",
+	}
+	if got := resp.Links(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Links() = %v, want %v", got, want)
+	}
+}
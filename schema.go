@@ -0,0 +1,69 @@
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// SchemaValidator validates a JSON document against a JSON Schema
+// Validate returns a description of each violation, or an error if the schema or document couldn't be processed
+type SchemaValidator interface {
+	Validate(schema, document []byte) (violations []string, err error)
+}
+
+var (
+	schemaValidatorMu sync.RWMutex
+	schemaValidator   SchemaValidator
+)
+
+// RegisterSchemaValidator sets the SchemaValidator used by WithJSONSchema
+// No validator is registered by default, so fetcher doesn't depend on a JSON Schema implementation
+func RegisterSchemaValidator(validator SchemaValidator) {
+	schemaValidatorMu.Lock()
+	defer schemaValidatorMu.Unlock()
+	schemaValidator = validator
+}
+
+// getSchemaValidator returns the registered SchemaValidator
+func getSchemaValidator() SchemaValidator {
+	schemaValidatorMu.RLock()
+	defer schemaValidatorMu.RUnlock()
+	return schemaValidator
+}
+
+// SchemaError is returned by Decode when the body doesn't match the WithJSONSchema schema
+type SchemaError struct {
+	Violations []string
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("fetcher: body doesn't match JSON schema: %s", strings.Join(e.Violations, "; "))
+}
+
+// WithJSONSchema validates the body against the given JSON Schema with the registered SchemaValidator before it's decoded
+// The body is buffered to validate it, so it remains available from Bytes afterwards
+func WithJSONSchema(schema []byte) DecodeOption {
+	return func(c context.Context, resp *Response) error {
+		validator := getSchemaValidator()
+		if validator == nil {
+			return errors.New("no JSON schema validator registered")
+		}
+
+		body, err := resp.Bytes()
+		if err != nil {
+			return err
+		}
+
+		violations, err := validator.Validate(schema, body)
+		if err != nil {
+			return err
+		}
+		if len(violations) > 0 {
+			return &SchemaError{Violations: violations}
+		}
+		return nil
+	}
+}
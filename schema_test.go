@@ -0,0 +1,88 @@
+package fetcher
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+// requiredValidator only checks the "required" keyword of an object schema
+type requiredValidator struct{}
+
+func (requiredValidator) Validate(schema, document []byte) ([]string, error) {
+	var s struct {
+		Required []string `json:"required"`
+	}
+	if err := json.Unmarshal(schema, &s); err != nil {
+		return nil, err
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(document, &doc); err != nil {
+		return nil, err
+	}
+
+	var violations []string
+	for _, key := range s.Required {
+		if _, ok := doc[key]; !ok {
+			violations = append(violations, fmt.Sprintf("missing required property %q", key))
+		}
+	}
+	return violations, nil
+}
+
+func TestJSONSchema(t *testing.T) {
+	c := context.Background()
+	schema := []byte(`{"type": "object", "required": ["URL", "Count"]}`)
+
+	tests := []struct {
+		name           string
+		body           string
+		wantViolations []string
+	}{
+		{"valid", `{"URL": "https://example.com", "Count": 2}`, nil},
+		{"missing properties", `{"Other": true}`, []string{`missing required property "URL"`, `missing required property "Count"`}},
+	}
+
+	RegisterSchemaValidator(requiredValidator{})
+	defer RegisterSchemaValidator(nil)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sd := &serverData{
+				headers:    map[string]string{ContentTypeHeader: ContentTypeJSON},
+				body:       []byte(tt.body),
+				statusCode: http.StatusOK,
+			}
+			ts := testServerHelper(t, sd)
+			defer ts.Close()
+
+			cl, err := NewClient(c)
+			if err != nil {
+				t.Fatalf("NewClient failed: %v", err)
+			}
+			resp, err := cl.Get(c, ts.URL)
+			if err != nil {
+				t.Fatalf("cl.Get failed: %v", err)
+			}
+			defer resp.Close()
+
+			var obj testObject
+			err = resp.Decode(c, &obj, WithJSONSchema(schema))
+
+			var schemaErr *SchemaError
+			switch {
+			case tt.wantViolations == nil && err != nil:
+				t.Fatalf("Decode failed: %v", err)
+			case tt.wantViolations == nil && obj.URL != "https://example.com":
+				t.Errorf("decoded %+v after validating", obj)
+			case tt.wantViolations != nil && !errors.As(err, &schemaErr):
+				t.Fatalf("Decode err = %v, want *SchemaError", err)
+			case tt.wantViolations != nil && fmt.Sprint(schemaErr.Violations) != fmt.Sprint(tt.wantViolations):
+				t.Errorf("violations = %q, want %q", schemaErr.Violations, tt.wantViolations)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+// Package zstd registers a zstd Compressor and Decompressor with fetcher
+// Import it to allow WithZstdPayload and WithDecompression to handle Content-Encoding: zstd
+package zstd
+
+import (
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/nozzle/fetcher"
+)
+
+// Encoding is the Content-Encoding value for zstd compressed bodies
+const Encoding = "zstd"
+
+func init() {
+	fetcher.RegisterCompressor(Encoding, compress)
+	fetcher.RegisterDecompressor(Encoding, decompress)
+}
+
+// WithZstdPayload zstd compresses the Request payload and sets the Content-Encoding header to zstd
+func WithZstdPayload() fetcher.RequestOption {
+	return fetcher.WithCompressedPayload(Encoding)
+}
+
+func compress(w io.Writer) (io.WriteCloser, error) {
+	return zstd.NewWriter(w)
+}
+
+// decompress returns an io.ReadCloser, so the Response closes the decoder even if the body isn't fully read
+func decompress(r io.Reader) (io.Reader, error) {
+	dec, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	return decoder{dec}, nil
+}
+
+// decoder releases the goroutine and buffers held by the zstd.Decoder once the body has been fully read or closed
+type decoder struct {
+	*zstd.Decoder
+}
+
+func (d decoder) Read(p []byte) (int, error) {
+	n, err := d.Decoder.Read(p)
+	if err != nil {
+		d.Decoder.Close()
+	}
+	return n, err
+}
+
+// Close implements io.Closer, the zstd.Decoder can be closed more than once
+func (d decoder) Close() error {
+	d.Decoder.Close()
+	return nil
+}
@@ -0,0 +1,116 @@
+package zstd_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/nozzle/fetcher"
+	fetcherzstd "github.com/nozzle/fetcher/zstd"
+)
+
+func TestZstdRoundTrip(t *testing.T) {
+	c := context.Background()
+	payload := map[string]interface{}{"URL": "https://nozzle.io/", "Count": 30}
+
+	// the server decompresses the request payload and echoes it back zstd compressed
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(fetcher.ContentEncodingHeader) != fetcherzstd.Encoding {
+			t.Errorf("Content-Encoding = %s, want %s", r.Header.Get(fetcher.ContentEncodingHeader), fetcherzstd.Encoding)
+		}
+		dec, err := zstd.NewReader(r.Body)
+		if err != nil {
+			t.Errorf("zstd.NewReader failed: %v", err)
+			return
+		}
+		defer dec.Close()
+		body, err := ioutil.ReadAll(dec)
+		if err != nil {
+			t.Errorf("reading request body failed: %v", err)
+			return
+		}
+
+		w.Header().Set(fetcher.ContentTypeHeader, fetcher.ContentTypeJSON)
+		w.Header().Set(fetcher.ContentEncodingHeader, fetcherzstd.Encoding)
+		enc, err := zstd.NewWriter(w)
+		if err != nil {
+			t.Errorf("zstd.NewWriter failed: %v", err)
+			return
+		}
+		enc.Write(body)
+		enc.Close()
+	}))
+	defer ts.Close()
+
+	cl, err := fetcher.NewClient(c)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	resp, err := cl.Post(c, ts.URL,
+		fetcher.WithJSONPayload(payload),
+		fetcherzstd.WithZstdPayload(),
+		fetcher.WithDecompression(fetcherzstd.Encoding),
+	)
+	if err != nil {
+		t.Fatalf("cl.Post failed: %v", err)
+	}
+	defer resp.Close()
+
+	got := map[string]interface{}{}
+	if err = resp.Decode(c, &got); err != nil {
+		t.Fatalf("resp.Decode failed: %v", err)
+	}
+	if got["URL"] != "https://nozzle.io/" || got["Count"] != float64(30) {
+		t.Errorf("got = %v, want %v", got, payload)
+	}
+}
+
+func TestZstdCloseAfterPartialRead(t *testing.T) {
+	c := context.Background()
+	goroutines := runtime.NumGoroutine()
+
+	// large enough that the decoder is still running when the body is closed
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(fetcher.ContentEncodingHeader, fetcherzstd.Encoding)
+		enc, err := zstd.NewWriter(w)
+		if err != nil {
+			t.Errorf("zstd.NewWriter failed: %v", err)
+			return
+		}
+		enc.Write(bytes.Repeat([]byte("0123456789"), 1<<20))
+		enc.Close()
+	}))
+
+	cl, err := fetcher.NewClient(c)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	for i := 0; i < 10; i++ {
+		resp, err := cl.Get(c, ts.URL, fetcher.WithDecompression(fetcherzstd.Encoding))
+		if err != nil {
+			t.Fatalf("cl.Get failed: %v", err)
+		}
+		if got, err := resp.Peek(10); err != nil || string(got) != "0123456789" {
+			t.Fatalf("resp.Peek = %q, %v, want 0123456789", got, err)
+		}
+		resp.Close()
+	}
+	ts.Close()
+
+	// the decoder goroutines end with the Response, along with the connection goroutines once the server is closed
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > goroutines && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := runtime.NumGoroutine(); got > goroutines {
+		t.Errorf("%d goroutines after closing the responses, want at most %d", got, goroutines)
+	}
+}